@@ -9,6 +9,8 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform/backend"
 	"github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/cost"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote"
 	"github.com/hashicorp/terraform/command/format"
 	"github.com/hashicorp/terraform/config/module"
 	"github.com/hashicorp/terraform/terraform"
@@ -34,6 +36,21 @@ func (b *Backend) apply(stopCtx context.Context, cancelCtx context.Context, op *
 	defer func() { b.ContextOpts.Hooks = old }()
 	b.ContextOpts.Hooks = append(b.ContextOpts.Hooks, countHook, stateHook)
 
+	// If a previous operation appears to have crashed mid-apply, its lock
+	// info will still be sitting in the blob's metadata even though we're
+	// about to (re-)acquire the lease ourselves below. Surface that now, so
+	// the operator gets a clearer signal than the generic lock-conflict
+	// error the locker would otherwise produce on its own.
+	if preflight, err := b.State(op.Workspace); err == nil {
+		if rs, ok := preflight.(*remote.State); ok {
+			if info, err := rs.PeekLockInfo(); err == nil && info != nil && b.CLI != nil {
+				b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+					"[reset][bold][yellow]Warning:[reset][yellow] state is already locked by operation %q (created %s). This may be a previous apply that didn't finish; continuing to attempt this one.[reset]",
+					info.Operation, info.Created)))
+			}
+		}
+	}
+
 	// Get our context
 	tfCtx, opState, err := b.context(op)
 	if err != nil {
@@ -41,6 +58,24 @@ func (b *Backend) apply(stopCtx context.Context, cancelCtx context.Context, op *
 		return
 	}
 
+	// If this apply is using a plan that was saved remotely with -out, make
+	// sure the state it was computed against hasn't changed since: we
+	// always refresh and re-plan server-side below regardless, but a drifted
+	// ETag means the plan the operator reviewed no longer reflects reality.
+	if op.Plan != nil {
+		if rs, ok := opState.(*remote.State); ok {
+			artifact, err := fetchPlanArtifact(rs, op.Workspace)
+			if err != nil {
+				runningOp.Err = fmt.Errorf("error checking remote plan artifact: %s", err)
+				return
+			}
+			if artifact != nil && artifact.ParentStateETag != "" && artifact.ParentStateETag != rs.ETag() {
+				runningOp.Err = fmt.Errorf("state has changed since this plan was created (expected ETag %q, got %q); re-run terraform plan", artifact.ParentStateETag, rs.ETag())
+				return
+			}
+		}
+	}
+
 	// Setup the state
 	runningOp.State = tfCtx.State()
 
@@ -59,6 +94,38 @@ func (b *Backend) apply(stopCtx context.Context, cancelCtx context.Context, op *
 	}
 	dispPlan := format.NewPlan(plan)
 	emptyPlan := dispPlan.Empty()
+
+	var costReport *cost.Report
+	if !emptyPlan && b.costEstimator != nil {
+		var err error
+		if costReport, err = b.estimateCost(op.Workspace, plan); err != nil {
+			if b.CLI != nil {
+				b.CLI.Error(fmt.Sprintf("Warning: error estimating cost: %s", err))
+			}
+		} else if b.maxMonthlyCostIncrease > 0 && costReport.DiffTotalMonthlyCost > b.maxMonthlyCostIncrease {
+			runningOp.Err = fmt.Errorf("projected monthly cost increase of %.2f exceeds the configured max_monthly_cost_increase of %.2f",
+				costReport.DiffTotalMonthlyCost, b.maxMonthlyCostIncrease)
+			return
+		}
+	}
+
+	if !emptyPlan && b.policyScanner != nil && !b.skipPolicyScan {
+		findings, err := b.scanPolicy(op.Workspace, plan)
+		if err != nil {
+			if b.CLI != nil {
+				b.CLI.Error(fmt.Sprintf("Warning: error running policy scan: %s", err))
+			}
+		} else {
+			b.renderPolicyFindings(findings)
+			// The severity gate applies even under -auto-approve; only
+			// skip_policy_scan (break-glass) bypasses it.
+			if findings.MeetsOrExceeds(b.failOnSeverity) {
+				runningOp.Err = fmt.Errorf("policy scan found findings at or above the configured fail_on_severity threshold")
+				return
+			}
+		}
+	}
+
 	if (op.UIOut != nil && op.UIIn != nil) && ((op.Destroy && (!op.DestroyForce && !op.AutoApprove)) || (!op.Destroy && !op.AutoApprove && !emptyPlan)) {
 		var desc, query string
 		if op.Destroy {
@@ -74,7 +141,7 @@ func (b *Backend) apply(stopCtx context.Context, cancelCtx context.Context, op *
 
 		if !emptyPlan {
 			// Display the plan of what we are going to apply/destroy.
-			b.render(dispPlan)
+			b.render(dispPlan, costReport)
 			b.CLI.Output("")
 		}
 
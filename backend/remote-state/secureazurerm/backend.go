@@ -2,43 +2,196 @@ package secureazurerm
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
-	"sort"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/storage"
-	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/cost"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/policy"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account/blob"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/auth"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/retry"
 	"github.com/hashicorp/terraform/helper/schema"
-	"github.com/hashicorp/terraform/state"
-	"github.com/hashicorp/terraform/state/remote"
-	"github.com/hashicorp/terraform/terraform"
 )
 
 // Backend maintains the remote state in Azure.
 type Backend struct {
 	*schema.Backend
 
-	// Fields used by Storage Account:
-	blobClient    storage.BlobStorageClient
-	containerName string
-	blobName      string
-	leaseID       string
+	// container is the state container, set up by account.Setup in
+	// configure. StateMgr/Workspaces/DeleteWorkspace (states.go) pass it to
+	// blob.Setup to get at individual workspaces' state blobs.
+	container *account.Container
+
+	// Resolved credentials used to authenticate to the management and vault
+	// endpoints, shared by everything under remote/.
+	creds *auth.Credentials
+
+	// keyVaultAuthMode selects how state key vaults authorize data-plane access.
+	keyVaultAuthMode keyvault.AuthorizationMode
+
+	// secretStoreType selects the backend that holds masked state attribute
+	// values: "" or "azure_key_vault" (the default) uses the workspace's Key
+	// Vault; "vault" uses vaultConfig to talk to a HashiCorp Vault KV v2
+	// engine instead. See states.go's setupSecretStore.
+	secretStoreType string
+	vaultConfig     *vaultConfig
+
+	// Cost estimation:
+	costEstimator          cost.Estimator
+	maxMonthlyCostIncrease float64
+
+	// Policy scanning:
+	policyScanner  policy.Scanner
+	failOnSeverity policy.Severity
+	skipPolicyScan bool
+
+	// snapshotRetention, when non-nil, is pruned down to after every
+	// successful PersistState. Nil (the default, snapshot_retention unset)
+	// disables pruning.
+	snapshotRetention *blob.RetentionPolicy
+
+	// retryPolicy governs how transient Azure errors are retried across the
+	// blob and key vault data-plane calls. Defaults to retry.DefaultPolicy
+	// when the retry_* schema fields are left unset.
+	retryPolicy retry.Policy
+
+	// props is handed to every per-workspace key vault (see states.go's
+	// setupKeyVault) and to remote.State itself, carrying the
+	// subscription/credential context those need plus the declarative
+	// settings below that apply to every workspace (state encryption,
+	// access_policy reconciliation).
+	props properties.Properties
 }
 
 // config stores backend configuration.
 type config struct {
 	// Resource Group:
 	ResourceGroupName string
+	Location          string
 
 	// Azure Storage Account:
-	StorageAccountName string
-	AccessKey          string
-	ContainerName      string
+	StorageAccountName          string
+	StorageAccountResourceGroup string // ignored unless StorageAccountName is set; empty defaults to ResourceGroupName.
+	SASToken                    string // when set, blob data-plane requests are authorized with this account SAS instead of a key or Azure AD, taking priority over both.
+	ContainerName               string
+	UseAzureADAuth              bool // when true, blob data-plane requests are authorized via Azure AD instead of a storage account key. Ignored when SASToken is set.
+
+	// Provision, when true, creates the resource group, storage account,
+	// and container when they don't already exist. When false, a missing
+	// resource is a configuration error instead.
+	Provision bool
+
+	// AccountTier, AccountReplication, AccountKind, and AccessTier configure
+	// a storage account created by Provision/Bootstrap. AccountReplication
+	// is a replication type suffix (e.g. "LRS", "ZRS"), not a full SKU name.
+	AccountTier        string
+	AccountReplication string
+	AccountKind        string
+	AccessTier         string
+
+	// StorageCMKVaultURI, StorageCMKKeyName, and StorageCMKKeyVersion select
+	// a Key Vault key account.Setup switches the storage account's
+	// encryption to, instead of the Microsoft-managed default.
+	// StorageCMKUserAssignedIdentityID, when set, is the identity the
+	// account authenticates to that vault with; empty has account.Setup
+	// assign and use a system-assigned identity instead.
+	StorageCMKVaultURI               string
+	StorageCMKKeyName                string
+	StorageCMKKeyVersion             string
+	StorageCMKUserAssignedIdentityID string
+
+	// NetworkAllowedSubnetIDs, NetworkAllowedIPRanges, and ClientIP allow-list
+	// traffic through a newly created storage account's firewall; see
+	// properties.Properties's fields of the same name. PrivateEndpointSubnetID
+	// and PrivateDNSZoneID provision (and DNS-link) a Private Endpoint for it.
+	NetworkAllowedSubnetIDs []string
+	NetworkAllowedIPRanges  []string
+	ClientIP                string
+	PrivateEndpointSubnetID string
+	PrivateDNSZoneID        string
+
+	// StateEncryptionKeyName, when set, client-side envelope-encrypts the
+	// state blob's contents under this Key Vault key, on top of whatever
+	// server-side encryption the storage account already provides.
+	// StateEncryptionKeyVersion pins to a specific key version; empty
+	// resolves to the key's current version.
+	StateEncryptionKeyName    string
+	StateEncryptionKeyVersion string
+
+	// StateEncryptionKey, when set, is a base64-encoded 32-byte AES-256 key
+	// used directly as the envelope encryption DEK, bypassing Key Vault
+	// wrapping entirely — a customer-provided-key mode for operators who
+	// already manage the key outside of Key Vault. Mutually exclusive with
+	// StateEncryptionKeyName.
+	StateEncryptionKey string
 
 	// Credentials:
 	Environment    string
 	SubscriptionID string
 	TenantID       string
+
+	// Bootstrap, when non-nil, makes configure provision the storage
+	// account and key vault with hardened, opinionated defaults (TLS 1.2,
+	// blob versioning, soft delete, a locked-down network ACL, RBAC role
+	// grants) instead of Provision's bare-minimum creation. nil (the
+	// default, bootstrap unset) leaves Provision's existing behavior alone.
+	Bootstrap *bootstrapConfig
+
+	// AccessPolicies declares which resources' managed identities
+	// PersistState should grant access to the state key vault, via one or
+	// more access_policy {} blocks. See properties.AccessPolicyConfig.
+	AccessPolicies []properties.AccessPolicyConfig
+}
+
+// vaultConfig holds the connection details for the HashiCorp Vault KV v2
+// engine backing masked state attributes, set via the vault {} block when
+// secret_store = "vault".
+type vaultConfig struct {
+	// Address is the Vault server's API address, e.g. "https://vault:8200".
+	Address string
+	// AuthMethod selects how to obtain a Vault token: "token" (the default,
+	// Token is used as-is), "approle" (RoleID/SecretID are exchanged via
+	// auth/approle/login), or "azure" (RoleID names the Vault role; the
+	// login JWT comes from the backend's own managed identity via IMDS).
+	AuthMethod string
+	// Token authenticates to Vault directly. Used when AuthMethod is "token".
+	Token string
+	// RoleID is the AppRole role ID when AuthMethod is "approle", or the
+	// Vault role name when AuthMethod is "azure".
+	RoleID string
+	// SecretID is the AppRole secret ID. Used when AuthMethod is "approle".
+	SecretID string
+	// MountPath is the KV v2 secrets engine's mount path. Defaults to "secret".
+	MountPath string
+	// Namespace selects a Vault Enterprise namespace. Empty uses the root namespace.
+	Namespace string
+}
+
+// bootstrapConfig holds the opt-in, hardened provisioning options set via
+// the bootstrap {} block.
+type bootstrapConfig struct {
+	// Location overrides the top-level location for bootstrapped
+	// resources. Empty uses the top-level location.
+	Location string
+	// SKU is the storage account SKU to create, e.g. "Standard_LRS".
+	SKU string
+	// KeyVaultSKU is the key vault SKU to create: "standard" or "premium".
+	KeyVaultSKU string
+	// SoftDeleteRetentionDays is how many days the storage account's
+	// soft-deleted blobs, and the key vault's soft-deleted tombstone, are
+	// retained for.
+	SoftDeleteRetentionDays int32
+	// PurgeProtection enables purge protection on the bootstrapped key vault.
+	PurgeProtection bool
+	// NetworkACLs lists the subnet resource IDs allowed through the
+	// storage account's firewall. When non-empty, public network access is
+	// denied by default and only these subnets are let through.
+	NetworkACLs []string
 }
 
 // New creates a new backend for remote state stored in Azure storage account and key vault.
@@ -52,23 +205,134 @@ func New() backend.Backend {
 				Required:    true,
 				Description: "The resource group name.",
 			},
+			"location": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "westeurope",
+				Description: "The Azure region to create the resource group/storage account in, when provision creates them.",
+			},
 
 			// Azure Storage Account:
 			"storage_account_name": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The name of the storage account.",
+				Optional:    true,
+				Description: "Use this pre-existing storage account as-is instead of auto-provisioning one named after resource_group_name — bring your own account for a resource group shared with other apps. Unset discovers (or creates) an account prefixed with resource_group_name instead.",
 			},
-			"access_key": { // storage account access key.
+			"storage_account_resource_group": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The access key.",
+				Optional:    true,
+				Description: "Resource group storage_account_name lives in. Unset defaults to resource_group_name. Ignored when storage_account_name is unset.",
+			},
+			"sas_token": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "An account SAS query string to authorize blob data-plane requests with, instead of a storage account key or Azure AD token. Takes priority over use_azuread_auth.",
 			},
 			"container_name": {
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "The container name.",
 			},
+			"provision": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Auto-provision the resource group, storage account, and container when they don't already exist. Set to false to require pre-created infrastructure.",
+			},
+			"account_tier": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "Standard",
+				Description: "Storage account tier to create: \"Standard\" or \"Premium\".",
+			},
+			"account_replication_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "LRS",
+				Description: "Storage account replication to create: \"LRS\", \"GRS\", \"RAGRS\", \"ZRS\", or \"GZRS\".",
+			},
+			"account_kind": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "BlobStorage",
+				Description: "Storage account kind to create: \"StorageV2\" or \"BlobStorage\". ZRS/GZRS replication requires \"StorageV2\".",
+			},
+			"access_tier": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "Hot",
+				Description: "Storage account access tier to create: \"Hot\" or \"Cool\".",
+			},
+			"use_azuread_auth": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Authorize blob data-plane requests with Azure AD (Storage Blob Data Contributor) instead of a storage account access key. When true, the storage account's keys are never listed.",
+			},
+			"storage_cmk_vault_uri": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Vault URI of a Key Vault key (e.g. \"https://myvault.vault.azure.net/\") to switch the storage account's encryption to, instead of the Microsoft-managed default. Unset disables customer-managed-key encryption.",
+			},
+			"storage_cmk_key_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name of the Key Vault key at storage_cmk_vault_uri. Required when storage_cmk_vault_uri is set.",
+			},
+			"storage_cmk_key_version": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Version of storage_cmk_key_name to use. Unset uses the key's current version.",
+			},
+			"storage_cmk_user_assigned_identity_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Resource ID of a user-assigned identity for the storage account to authenticate to the vault with. Unset assigns and uses a system-assigned identity instead.",
+			},
+			"network_allowed_subnet_ids": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Subnet resource IDs allowed through a newly created storage account's firewall. When set together with or in place of network_allowed_ip_ranges, the account's NetworkRuleSet defaults to deny. Ignored for a pre-existing account (storage_account_name set).",
+			},
+			"network_allowed_ip_ranges": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "IP ranges allowed through a newly created storage account's firewall, in addition to network_allowed_subnet_ids and client_ip/the caller's own detected egress IP.",
+			},
+			"client_ip": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "IP address to allow-list on a newly created storage account's firewall instead of detecting the caller's own egress IP. Only used when network_allowed_subnet_ids or network_allowed_ip_ranges is set.",
+			},
+			"private_endpoint_subnet_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Subnet to provision a Private Endpoint for the storage account's blob sub-resource into. Unset skips private endpoint provisioning.",
+			},
+			"private_dns_zone_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Private DNS Zone to link the private endpoint to, via a DNS zone group entry. Ignored unless private_endpoint_subnet_id is set.",
+			},
+			"state_encryption_key_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name of a Key Vault key used to client-side envelope-encrypt the state blob, on top of the storage account's own encryption at rest. Unset disables envelope encryption.",
+			},
+			"state_encryption_key_version": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Version of state_encryption_key_name to encrypt new writes with. Unset uses the key's current version. Older versions remain usable for decrypting existing state regardless of this setting.",
+			},
+			"state_encryption_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Base64-encoded 32-byte AES-256 key to envelope-encrypt the state blob with directly, bypassing Key Vault wrapping. Mutually exclusive with state_encryption_key_name.",
+			},
 
 			// Credentials:
 			"tenant_id": {
@@ -81,6 +345,312 @@ func New() backend.Backend {
 				Required:    true, // ensure that you don't accidently write to the wrong subscription incorrectly set by 'az'.
 				Description: "The subscription ID.",
 			},
+			"client_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Client ID of the service principal or managed identity to authenticate as. Falls back to the Azure CLI when unset.",
+			},
+			"client_secret": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Client secret of the service principal named by client_id.",
+			},
+			"client_certificate_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a client certificate (PFX) to authenticate the service principal named by client_id.",
+			},
+			"client_certificate_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Password for the certificate at client_certificate_path.",
+			},
+			"oidc_token": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "A federated OIDC token to exchange for credentials (e.g. a CI system's OIDC token).",
+			},
+			"oidc_token_file_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a file containing a federated OIDC token, re-read on every authentication. Used in place of oidc_token when set.",
+			},
+			"use_msi": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Authenticate using IMDS-based managed identity, e.g. AKS workload identity.",
+			},
+			"msi_endpoint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Custom MSI endpoint to use instead of the standard IMDS endpoint.",
+			},
+			"user_assigned_identity_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Client ID of a user-assigned managed identity to authenticate as. Unset selects the system-assigned identity when use_msi is true.",
+			},
+
+			// Key Vault authorization:
+			"key_vault_authorization_mode": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     string(keyvault.AccessPolicy),
+				Description: "How state key vaults authorize data-plane access: \"AccessPolicy\" or \"RBAC\".",
+			},
+
+			// Secret store:
+			"secret_store": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "azure_key_vault",
+				Description: "Backend that holds masked state attribute values: \"azure_key_vault\" (default) or \"vault\" (HashiCorp Vault KV v2, configured via the vault block).",
+			},
+			"vault": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "HashiCorp Vault connection details, used when secret_store is \"vault\".",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"address": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Vault server API address, e.g. \"https://vault:8200\".",
+						},
+						"auth_method": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "token",
+							Description: "How to authenticate to Vault: \"token\", \"approle\" (role_id/secret_id), or \"azure\" (role_id names the Vault role; the backend's own managed identity supplies the login JWT).",
+						},
+						"token": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Token used to authenticate to Vault. Required when auth_method is \"token\".",
+						},
+						"role_id": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "AppRole role ID, or the Vault role name for azure auth. Required when auth_method is \"approle\" or \"azure\".",
+						},
+						"secret_id": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "AppRole secret ID. Required when auth_method is \"approle\".",
+						},
+						"mount_path": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "secret",
+							Description: "Mount path of the KV version 2 secrets engine.",
+						},
+						"namespace": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Vault Enterprise namespace. Unset uses the root namespace.",
+						},
+					},
+				},
+			},
+
+			// Declarative access-policy reconciliation:
+			"access_policy": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Grants a resource's managed identity access to the state key vault. Reconciled every PersistState: the identity's current principal_id/tenant_id is resolved from the resource's state and diffed against the vault's existing access policies.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"resource_address": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Address of the resource whose managed identity is granted access, e.g. \"azurerm_linux_virtual_machine.app\" or \"module.foo.azurerm_kubernetes_cluster.main\".",
+						},
+						"permissions": {
+							Type:        schema.TypeList,
+							Required:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "Secret permissions to grant: any of \"get\", \"list\", \"set\", \"delete\".",
+						},
+					},
+				},
+			},
+
+			// Bootstrap:
+			"bootstrap": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Opt-in, hardened provisioning of the storage account and key vault (TLS 1.2, blob versioning, soft delete, network ACLs, RBAC role grants) on top of what provision creates by default. Makes the backend usable from a fresh subscription without a separate bootstrap module.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"location": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Overrides location for bootstrapped resources. Unset uses the top-level location.",
+						},
+						"sku": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "Standard_LRS",
+							Description: "Storage account SKU to create.",
+						},
+						"key_vault_sku": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "standard",
+							Description: "Key Vault SKU to create: \"standard\" or \"premium\".",
+						},
+						"soft_delete_retention_days": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     90,
+							Description: "Retention, in days, for the storage account's soft-deleted blobs and the key vault's soft-deleted tombstone.",
+						},
+						"purge_protection": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Enable purge protection on the bootstrapped key vault.",
+						},
+						"network_acls": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "Subnet resource IDs allowed through the storage account's firewall. When set, public network access is denied by default and only these subnets are let through.",
+						},
+					},
+				},
+			},
+
+			// Cost estimation:
+			"infracost_enabled": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether to run Infracost against the plan and show the cost delta.",
+			},
+			"infracost_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "infracost",
+				Description: "Path to the infracost binary.",
+			},
+			"max_monthly_cost_increase": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Default:     0, // 0 means no threshold is enforced.
+				Description: "Require explicit confirmation (or block) when the projected monthly cost delta exceeds this amount.",
+			},
+
+			// Policy scanning:
+			"policy_scanner": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Policy scanner to run before apply: \"checkov\" or \"tfsec\". Unset disables scanning.",
+			},
+			"policy_scanner_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the policy scanner binary. Defaults to the scanner's name.",
+			},
+			"policy_skip_checks": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Rule/check IDs to skip during the policy scan.",
+			},
+			"fail_on_severity": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "CRITICAL",
+				Description: "Minimum severity (LOW/MEDIUM/HIGH/CRITICAL) that aborts apply.",
+			},
+			"skip_policy_scan": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Break-glass flag to skip the policy scanning gate entirely.",
+			},
+
+			// Audit logging:
+			"audit_container_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Record every Put/Delete/Lock/Unlock against a workspace's state blob to an append-blob audit log kept in this separate container. Unset disables audit logging entirely.",
+			},
+			"audit_immutability_days": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "Apply a time-based retention immutability policy to the audit container for this many days, with protected append writes allowed. 0 disables it. Ignored unless audit_container_name is set.",
+			},
+			"audit_legal_hold": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Place a legal hold on the audit container, blocking deletion of any blob in it until the hold is lifted. Ignored unless audit_container_name is set.",
+			},
+
+			// Snapshot retention:
+			"snapshot_retention": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Retention policy for the state blob's snapshots. Unset keeps every snapshot forever.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"keep_last": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     0,
+							Description: "Always keep this many of the most recent snapshots. 0 disables the rule.",
+						},
+						"keep_within": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Keep every snapshot younger than this duration (e.g. \"168h\"). Unset disables the rule.",
+						},
+						"keep_daily": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     0,
+							Description: "Keep the most recent snapshot from each of this many distinct days that have one. 0 disables the rule.",
+						},
+						"keep_weekly": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     0,
+							Description: "Keep the most recent snapshot from each of this many distinct ISO weeks that have one. 0 disables the rule.",
+						},
+						"keep_monthly": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     0,
+							Description: "Keep the most recent snapshot from each of this many distinct calendar months that have one. 0 disables the rule.",
+						},
+					},
+				},
+			},
+
+			// Retry/backoff:
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0, // 0 means retry.DefaultPolicy's own schedule, not "don't retry".
+				Description: "Maximum number of retries for a transient Azure error, on top of the first attempt. 0 uses the default retry schedule.",
+			},
+			"retry_max_interval": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Cap on the exponential backoff delay between retries (e.g. \"30s\"). Unset uses the default retry schedule.",
+			},
+			"retry_max_elapsed": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Cap on the total time spent retrying a single call (e.g. \"5m\"). Unset uses the default retry schedule.",
+			},
 		},
 	}
 
@@ -94,170 +664,274 @@ func (b *Backend) configure(ctx context.Context) error {
 	// TODO: Check for right tenant-id and subscription.
 
 	// TODO: Replace with panic()?
-	if b.containerName != "" {
+	if b.container != nil {
 		return nil
 	}
 
 	// Get the resource data from the backend configuration.
 	data := schema.FromContextBackendConfig(ctx)
-	b.containerName = data.Get("container_name").(string)
 	c := config{
 		// Resource Group:
 		ResourceGroupName: data.Get("resource_group_name").(string),
+		Location:          data.Get("location").(string),
 
 		// Azure Storage Account:
-		StorageAccountName: data.Get("storage_account_name").(string),
-		AccessKey:          data.Get("access_key").(string),
-		ContainerName:      data.Get("container_name").(string),
+		StorageAccountName:          data.Get("storage_account_name").(string),
+		StorageAccountResourceGroup: data.Get("storage_account_resource_group").(string),
+		SASToken:                    data.Get("sas_token").(string),
+		ContainerName:               data.Get("container_name").(string),
+		UseAzureADAuth:              data.Get("use_azuread_auth").(bool),
+		Provision:                   data.Get("provision").(bool),
+
+		AccountTier:        data.Get("account_tier").(string),
+		AccountReplication: data.Get("account_replication_type").(string),
+		AccountKind:        data.Get("account_kind").(string),
+		AccessTier:         data.Get("access_tier").(string),
+
+		StorageCMKVaultURI:               data.Get("storage_cmk_vault_uri").(string),
+		StorageCMKKeyName:                data.Get("storage_cmk_key_name").(string),
+		StorageCMKKeyVersion:             data.Get("storage_cmk_key_version").(string),
+		StorageCMKUserAssignedIdentityID: data.Get("storage_cmk_user_assigned_identity_id").(string),
+
+		ClientIP:                data.Get("client_ip").(string),
+		PrivateEndpointSubnetID: data.Get("private_endpoint_subnet_id").(string),
+		PrivateDNSZoneID:        data.Get("private_dns_zone_id").(string),
+
+		StateEncryptionKeyName:    data.Get("state_encryption_key_name").(string),
+		StateEncryptionKeyVersion: data.Get("state_encryption_key_version").(string),
+		StateEncryptionKey:        data.Get("state_encryption_key").(string),
 
 		// Credentials:
 		Environment:    data.Get("environment").(string),
 		TenantID:       data.Get("tenant_id").(string),
 		SubscriptionID: data.Get("subscription_id").(string),
-
-		// TODO: Use MSI.
 	}
-
-	// TODO:
-	// 1. Check if the given resource group exists.
-	//   - If not, create it!
-	// 2. Check if the necessary Azure resources has been made in the resource group.
-	//   - If not, provision it!
-
-	blobClient, err := getBlobClient(c)
-	if err != nil {
-		return err
+	for _, v := range data.Get("network_allowed_subnet_ids").([]interface{}) {
+		c.NetworkAllowedSubnetIDs = append(c.NetworkAllowedSubnetIDs, v.(string))
 	}
-	b.blobClient = blobClient
-
-	return nil
-}
-
-func getBlobClient(c config) (storage.BlobStorageClient, error) {
-	var client storage.BlobStorageClient
-
-	env := azure.PublicCloud // currently only supports AzurePublicCloud.
-
-	accessKey, err := getAccessKey(c, env)
-	if err != nil {
-		return client, err
+	for _, v := range data.Get("network_allowed_ip_ranges").([]interface{}) {
+		c.NetworkAllowedIPRanges = append(c.NetworkAllowedIPRanges, v.(string))
 	}
 
-	storageClient, err := storage.NewClient(c.StorageAccountName, accessKey, env.StorageEndpointSuffix, storage.DefaultAPIVersion, true)
+	// Resolve credentials: explicit service principal, certificate, federated
+	// OIDC token, managed identity, or the Azure CLI, in that order.
+	creds, err := auth.New(auth.Config{
+		ClientID:                  data.Get("client_id").(string),
+		ClientSecret:              data.Get("client_secret").(string),
+		TenantID:                  c.TenantID,
+		ClientCertificatePath:     data.Get("client_certificate_path").(string),
+		ClientCertificatePassword: data.Get("client_certificate_password").(string),
+		OIDCToken:                 data.Get("oidc_token").(string),
+		OIDCTokenFilePath:         data.Get("oidc_token_file_path").(string),
+		UseMSI:                    data.Get("use_msi").(bool),
+		MSIEndpoint:               data.Get("msi_endpoint").(string),
+		UserAssignedIdentityID:    data.Get("user_assigned_identity_id").(string),
+		SubscriptionID:            c.SubscriptionID,
+	})
 	if err != nil {
-		return client, fmt.Errorf("error creating storage client for storage account %q: %s", c.StorageAccountName, err)
+		return fmt.Errorf("error resolving credentials: %s", err)
 	}
-
-	// Check if the given container exists.
-	blobService := storageClient.GetBlobService()
-	resp, err := blobService.ListContainers(storage.ListContainersParameters{Prefix: c.ContainerName, MaxResults: 1})
-	if err != nil {
-		return client, fmt.Errorf("failed to list containers")
+	b.creds = creds
+	b.keyVaultAuthMode = keyvault.AuthorizationMode(data.Get("key_vault_authorization_mode").(string))
+
+	// props carries the subscription/credential context and declarative
+	// settings every per-workspace key vault and remote.State need; see the
+	// props field's doc comment on Backend.
+	b.props = properties.Properties{
+		ResourceGroupName:           c.ResourceGroupName,
+		Location:                    c.Location,
+		KeyVaultPrefix:              c.ResourceGroupName,
+		SubscriptionID:              c.SubscriptionID,
+		TenantID:                    c.TenantID,
+		ObjectID:                    creds.ObjectID,
+		MgmtAuthorizer:              creds.MgmtAuthorizer,
+		EncryptionKeyName:           c.StateEncryptionKeyName,
+		EncryptionKeyVersion:        c.StateEncryptionKeyVersion,
+		AccessPolicies:              c.AccessPolicies,
+		StorageAccountName:          c.StorageAccountName,
+		StorageAccountResourceGroup: c.StorageAccountResourceGroup,
+		UseAzureADAuth:              c.UseAzureADAuth,
+		StorageToken:                creds.StorageToken,
+		SASToken:                    c.SASToken,
+		AccountTier:                 c.AccountTier,
+		AccountReplication:          c.AccountReplication,
+		AccountKind:                 c.AccountKind,
+		AccessTier:                  c.AccessTier,
+
+		StorageCMKVaultURI:               c.StorageCMKVaultURI,
+		StorageCMKKeyName:                c.StorageCMKKeyName,
+		StorageCMKKeyVersion:             c.StorageCMKKeyVersion,
+		StorageCMKUserAssignedIdentityID: c.StorageCMKUserAssignedIdentityID,
+
+		NetworkAllowedSubnetIDs: c.NetworkAllowedSubnetIDs,
+		NetworkAllowedIPRanges:  c.NetworkAllowedIPRanges,
+		ClientIP:                c.ClientIP,
+		PrivateEndpointSubnetID: c.PrivateEndpointSubnetID,
+		PrivateDNSZoneID:        c.PrivateDNSZoneID,
 	}
-	for _, container := range resp.Containers {
-		if container.Name == c.ContainerName {
-			return blobService, nil
+	if c.StateEncryptionKey != "" {
+		if c.StateEncryptionKeyName != "" {
+			return fmt.Errorf("state_encryption_key and state_encryption_key_name are mutually exclusive")
+		}
+		key, err := base64.StdEncoding.DecodeString(c.StateEncryptionKey)
+		if err != nil {
+			return fmt.Errorf("error decoding state_encryption_key: %s", err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("state_encryption_key must decode to 32 bytes (AES-256), got %d", len(key))
 		}
+		b.props.EncryptionKeyBase64 = c.StateEncryptionKey
 	}
-	return client, fmt.Errorf("cannot find container: %s", c.ContainerName)
-}
 
-// getAccessKey gets the access key needed to access the storage account that stores the remote state.
-func getAccessKey(c config, env azure.Environment) (string, error) {
-	if c.AccessKey != "" {
-		return c.AccessKey, nil
+	// Secret store.
+	b.secretStoreType = data.Get("secret_store").(string)
+	if raw := data.Get("vault").([]interface{}); len(raw) == 1 && raw[0] != nil {
+		block := raw[0].(map[string]interface{})
+		b.vaultConfig = &vaultConfig{
+			Address:    block["address"].(string),
+			AuthMethod: block["auth_method"].(string),
+			Token:      block["token"].(string),
+			RoleID:     block["role_id"].(string),
+			SecretID:   block["secret_id"].(string),
+			MountPath:  block["mount_path"].(string),
+			Namespace:  block["namespace"].(string),
+		}
 	}
-
-	/*
-		if c.ResourceGroupName != "" || c.SubscriptionID != "" || c.TenantID != "" {
-			return "", fmt.Errorf("resource_group_name and credentials must be provided when access_key is absent")
+	if b.secretStoreType == "vault" && b.vaultConfig == nil {
+		return fmt.Errorf("secret_store = \"vault\" requires a vault {} block")
+	}
+	if b.vaultConfig != nil {
+		switch b.vaultConfig.AuthMethod {
+		case "token":
+			if b.vaultConfig.Token == "" {
+				return fmt.Errorf("vault auth_method = \"token\" requires token to be set")
+			}
+		case "approle":
+			if b.vaultConfig.RoleID == "" || b.vaultConfig.SecretID == "" {
+				return fmt.Errorf("vault auth_method = \"approle\" requires role_id and secret_id to be set")
+			}
+		case "azure":
+			if b.vaultConfig.RoleID == "" {
+				return fmt.Errorf("vault auth_method = \"azure\" requires role_id to name the Vault role")
+			}
+		default:
+			return fmt.Errorf("unknown vault auth_method %q", b.vaultConfig.AuthMethod)
 		}
+	}
 
-		oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, c.TenantID)
-		if err != nil {
-			return "", err
+	// Bootstrap.
+	if raw := data.Get("bootstrap").([]interface{}); len(raw) == 1 && raw[0] != nil {
+		block := raw[0].(map[string]interface{})
+		bc := &bootstrapConfig{
+			Location:                block["location"].(string),
+			SKU:                     block["sku"].(string),
+			KeyVaultSKU:             block["key_vault_sku"].(string),
+			SoftDeleteRetentionDays: int32(block["soft_delete_retention_days"].(int)),
+			PurgeProtection:         block["purge_protection"].(bool),
 		}
-
-		spt, err := adal.NewServicePrincipalToken(*oauthConfig, c.ClientID, c.ClientSecret, env.ResourceManagerEndpoint)
-		if err != nil {
-			return "", err
+		for _, v := range block["network_acls"].([]interface{}) {
+			bc.NetworkACLs = append(bc.NetworkACLs, v.(string))
 		}
-
-		accountsClient := armStorage.NewAccountsClientWithBaseURI(env.ResourceManagerEndpoint, c.SubscriptionID)
-		accountsClient.Authorizer = autorest.NewBearerAuthorizer(spt)
-
-		keys, err := accountsClient.ListKeys(c.ResourceGroupName, c.StorageAccountName)
-		if err != nil {
-			return "", fmt.Errorf("error retrieving keys for storage account %q: %s", c.StorageAccountName, err)
+		c.Bootstrap = bc
+
+		b.props.AccountSKU = bc.SKU
+		b.props.RequireTLS12 = true
+		b.props.KeyVaultSKU = bc.KeyVaultSKU
+		b.props.SoftDeleteRetentionDays = bc.SoftDeleteRetentionDays
+		b.props.BlobSoftDeleteRetentionDays = bc.SoftDeleteRetentionDays
+		b.props.PurgeProtection = &bc.PurgeProtection
+		if len(bc.NetworkACLs) > 0 {
+			b.props.NetworkAllowedSubnetIDs = append(b.props.NetworkAllowedSubnetIDs, bc.NetworkACLs...)
 		}
+	}
 
-		if keys.Keys == nil {
-			return "", fmt.Errorf("nil key returned for storage account %q", c.StorageAccountName)
+	// Access policies.
+	for _, raw := range data.Get("access_policy").([]interface{}) {
+		block := raw.(map[string]interface{})
+		ap := properties.AccessPolicyConfig{
+			ResourceAddress: block["resource_address"].(string),
 		}
+		for _, v := range block["permissions"].([]interface{}) {
+			ap.Permissions = append(ap.Permissions, v.(string))
+		}
+		c.AccessPolicies = append(c.AccessPolicies, ap)
+	}
 
-		accessKeys := *keys.Keys
-		return *accessKeys[0].Value, nil
-	*/
-	return "", fmt.Errorf("access key not provided")
-}
-
-// States returns a list of the names of all remote states stored in separate unique blob.
-// They are all named after the workspace.
-// Basically, remote state = workspace = blob.
-func (b *Backend) States() ([]string, error) {
-	// Get blobs of container.
-	r, err := b.blobClient.GetContainerReference(b.containerName).ListBlobs(storage.ListBlobsParameters{})
-	if err != nil {
-		return nil, err
+	// Cost estimation.
+	if data.Get("infracost_enabled").(bool) {
+		b.costEstimator = &cost.CLIEstimator{BinaryPath: data.Get("infracost_path").(string)}
 	}
+	b.maxMonthlyCostIncrease = data.Get("max_monthly_cost_increase").(float64)
 
-	// List workspaces (which is equivalent to blobs) in the container.
-	workspaces := []string{}
-	for _, blob := range r.Blobs {
-		workspaces = append(workspaces, blob.Name)
+	// Policy scanning.
+	var skipChecks []string
+	for _, v := range data.Get("policy_skip_checks").([]interface{}) {
+		skipChecks = append(skipChecks, v.(string))
+	}
+	scannerPath := data.Get("policy_scanner_path").(string)
+	switch data.Get("policy_scanner").(string) {
+	case "checkov":
+		b.policyScanner = &policy.CheckovScanner{BinaryPath: scannerPath, SkipChecks: skipChecks}
+	case "tfsec":
+		b.policyScanner = &policy.TfsecScanner{BinaryPath: scannerPath, SkipChecks: skipChecks}
+	}
+	b.failOnSeverity = policy.ParseSeverity(data.Get("fail_on_severity").(string))
+	b.skipPolicyScan = data.Get("skip_policy_scan").(bool)
+
+	// Audit logging.
+	b.props.AuditContainerName = data.Get("audit_container_name").(string)
+	b.props.AuditImmutabilityDays = int32(data.Get("audit_immutability_days").(int))
+	b.props.AuditLegalHold = data.Get("audit_legal_hold").(bool)
+
+	// Snapshot retention.
+	if raw := data.Get("snapshot_retention").([]interface{}); len(raw) == 1 && raw[0] != nil {
+		block := raw[0].(map[string]interface{})
+		retention := &blob.RetentionPolicy{
+			KeepLast:    block["keep_last"].(int),
+			KeepDaily:   block["keep_daily"].(int),
+			KeepWeekly:  block["keep_weekly"].(int),
+			KeepMonthly: block["keep_monthly"].(int),
+		}
+		if s := block["keep_within"].(string); s != "" {
+			keepWithin, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("error parsing snapshot_retention.keep_within %q: %s", s, err)
+			}
+			retention.KeepWithin = keepWithin
+		}
+		b.snapshotRetention = retention
 	}
-	sort.Strings(workspaces[1:]) // default is placed first in the returned list.
-	return workspaces, nil
-}
 
-// DeleteState deletes remote state.
-func (b *Backend) DeleteState(name string) error {
-	if name == backend.DefaultStateName {
-		return fmt.Errorf("can't delete default state")
+	// Retry/backoff.
+	b.retryPolicy = retry.DefaultPolicy
+	b.retryPolicy.MaxRetries = data.Get("max_retries").(int)
+	if s := data.Get("retry_max_interval").(string); s != "" {
+		maxInterval, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("error parsing retry_max_interval %q: %s", s, err)
+		}
+		b.retryPolicy.MaxInterval = maxInterval
 	}
-	c := &Client{
-		blobClient:    b.blobClient,
-		containerName: b.containerName,
-		blobName:      name, // workspace name.
+	if s := data.Get("retry_max_elapsed").(string); s != "" {
+		maxElapsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("error parsing retry_max_elapsed %q: %s", s, err)
+		}
+		b.retryPolicy.MaxElapsed = maxElapsed
 	}
-	return c.Delete()
-}
 
-// State returns remote state specified by name.
-func (b *Backend) State(name string) (state.State, error) {
-	c := &Client{
-		blobClient:    b.blobClient,
-		containerName: b.containerName,
-		blobName:      name, // workspace name.
+	// Ensure the resource group exists, creating it unless provision = false.
+	// The storage account and container are provisioned separately, by
+	// account.Setup below, once the resource group exists.
+	if err := b.provisionInfra(ctx, c, creds); err != nil {
+		return fmt.Errorf("error provisioning infrastructure: %s", err)
 	}
-	s := &remote.State{Client: c}
 
-	// Check if blob exists.
-	exists, err := c.Exists()
+	container, err := account.Setup(ctx, &b.props, c.ContainerName)
 	if err != nil {
-		return nil, err // failed to check blob existence.
-	}
-	// If not exists, write empty state blob (no need for lock when the blob does not exists).
-	if !exists {
-		// Create new state in-memory.
-		if err := s.WriteState(terraform.NewState()); err != nil {
-			return nil, err
-		}
-		// Write that in-memory state to remote state.
-		if err := s.PersistState(); err != nil {
-			return nil, err
-		}
+		return fmt.Errorf("error setting up storage account/container: %s", err)
 	}
+	b.container = container
 
-	return s, nil
+	return nil
 }
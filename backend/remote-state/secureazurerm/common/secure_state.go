@@ -43,3 +43,37 @@ type SecureState struct {
 	RootOutputs      map[string]OutputState `json:"outputs,omitempty"`
 	Resources        []ResourceState        `json:"resources,omitempty"`
 }
+
+// ManifestVersion is the SecureState/Manifest "version" field value that
+// identifies the blob body as a Manifest rather than a monolithic
+// SecureState. RefreshState decodes whichever shape Version indicates;
+// any other value (in practice, the legacy "1") is treated as a
+// monolithic SecureState with Resources inline.
+const ManifestVersion = "2"
+
+// ManifestEntry points at the content-addressed chunk blob (see
+// blob.Blob.PutChunk/GetChunk) holding one resource's ResourceState, so the
+// manifest itself stays small even when a resource's own state is large.
+// Module/Mode/Type/Name duplicate fields that are also in the chunk body,
+// kept here too so the manifest alone is enough to tell which resources
+// exist without fetching every chunk.
+type ManifestEntry struct {
+	Module string `json:"module,omitempty"`
+	Mode   string `json:"mode"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+}
+
+// Manifest is the blob body PersistState writes once incremental
+// persistence is in effect: Resources is replaced by Entries, a list of
+// content hashes pointing at separate chunk blobs, so a resource whose
+// state didn't change between writes is never re-uploaded.
+type Manifest struct {
+	Version          string                 `json:"version"`
+	TerraformVersion string                 `json:"terraform_version"`
+	Serial           uint64                 `json:"serial"`
+	Lineage          string                 `json:"lineage"`
+	RootOutputs      map[string]OutputState `json:"outputs,omitempty"`
+	Entries          []ManifestEntry        `json:"resources,omitempty"`
+}
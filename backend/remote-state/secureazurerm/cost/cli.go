@@ -0,0 +1,90 @@
+package cost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// CLIEstimator estimates cost by shelling out to the Infracost CLI.
+type CLIEstimator struct {
+	// BinaryPath is the path to the infracost binary. Defaults to "infracost"
+	// (resolved via PATH) when empty.
+	BinaryPath string
+}
+
+// infracostOutput mirrors the subset of `infracost breakdown --format=json`
+// output that we care about.
+type infracostOutput struct {
+	TotalMonthlyCost     string `json:"totalMonthlyCost"`
+	TotalHourlyCost      string `json:"totalHourlyCost"`
+	PastTotalMonthlyCost string `json:"pastTotalMonthlyCost"`
+	DiffTotalMonthlyCost string `json:"diffTotalMonthlyCost"`
+	Projects             []struct {
+		Breakdown struct {
+			Resources []struct {
+				Name            string `json:"name"`
+				MonthlyCost     string `json:"monthlyCost"`
+				HourlyCost      string `json:"hourlyCost"`
+				PastMonthlyCost string `json:"pastMonthlyCost"`
+				DiffMonthlyCost string `json:"diffMonthlyCost"`
+			} `json:"resources"`
+		} `json:"breakdown"`
+	} `json:"projects"`
+}
+
+// Estimate runs `infracost breakdown --path=<planJSONPath> --format=json` and
+// parses the result into a Report.
+func (e *CLIEstimator) Estimate(ctx context.Context, planJSONPath string) (*Report, error) {
+	bin := e.BinaryPath
+	if bin == "" {
+		bin = "infracost"
+	}
+
+	cmd := exec.CommandContext(ctx, bin, "breakdown", fmt.Sprintf("--path=%s", planJSONPath), "--format=json")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running infracost: %s: %s", err, stderr.String())
+	}
+
+	var out infracostOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("error unmarshalling infracost output: %s", err)
+	}
+
+	report := &Report{
+		TotalMonthlyCost:     parseFloat(out.TotalMonthlyCost),
+		TotalHourlyCost:      parseFloat(out.TotalHourlyCost),
+		PastTotalMonthlyCost: parseFloat(out.PastTotalMonthlyCost),
+		DiffTotalMonthlyCost: parseFloat(out.DiffTotalMonthlyCost),
+	}
+	for _, project := range out.Projects {
+		for _, r := range project.Breakdown.Resources {
+			report.Resources = append(report.Resources, ResourceCost{
+				Name:            r.Name,
+				MonthlyCost:     parseFloat(r.MonthlyCost),
+				HourlyCost:      parseFloat(r.HourlyCost),
+				PastMonthlyCost: parseFloat(r.PastMonthlyCost),
+				DiffMonthlyCost: parseFloat(r.DiffMonthlyCost),
+			})
+		}
+	}
+	return report, nil
+}
+
+// parseFloat parses infracost's string-encoded decimal costs, treating an
+// empty or malformed value as zero rather than failing the whole report.
+func parseFloat(s string) float64 {
+	var f float64
+	if s == "" {
+		return 0
+	}
+	if _, err := fmt.Sscanf(s, "%f", &f); err != nil {
+		return 0
+	}
+	return f
+}
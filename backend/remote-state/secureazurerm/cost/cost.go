@@ -0,0 +1,33 @@
+// Package cost estimates the monthly/hourly cost delta of a Terraform plan
+// by shelling out to Infracost (or an equivalent pricing API) and parsing
+// its JSON report.
+package cost
+
+import (
+	"context"
+)
+
+// ResourceCost is the cost breakdown for a single planned resource.
+type ResourceCost struct {
+	Name            string  `json:"name"`
+	MonthlyCost     float64 `json:"monthlyCost"`
+	HourlyCost      float64 `json:"hourlyCost"`
+	PastMonthlyCost float64 `json:"pastMonthlyCost"`
+	DiffMonthlyCost float64 `json:"diffMonthlyCost"`
+}
+
+// Report is the parsed result of a cost estimation run against a plan.
+type Report struct {
+	TotalMonthlyCost     float64        `json:"totalMonthlyCost"`
+	TotalHourlyCost      float64        `json:"totalHourlyCost"`
+	PastTotalMonthlyCost float64        `json:"pastTotalMonthlyCost"`
+	DiffTotalMonthlyCost float64        `json:"diffTotalMonthlyCost"`
+	Resources            []ResourceCost `json:"resources"`
+}
+
+// Estimator estimates the cost of a Terraform JSON plan. Implementations
+// may shell out to a CLI tool or call an HTTP pricing API; the latter makes
+// it straightforward to stub out in tests.
+type Estimator interface {
+	Estimate(ctx context.Context, planJSONPath string) (*Report, error)
+}
@@ -0,0 +1,54 @@
+package cost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// HTTPEstimator estimates cost by POSTing the Terraform plan JSON to a
+// pricing API and decoding a Report directly from the response body. It
+// exists so that tests (and operators without the infracost CLI available)
+// can swap in an HTTP-based pricing service implementing the same Estimator
+// interface as CLIEstimator.
+type HTTPEstimator struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// Estimate reads the plan JSON at planJSONPath and posts it to Endpoint.
+func (e *HTTPEstimator) Estimate(ctx context.Context, planJSONPath string) (*Report, error) {
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	planJSON, err := os.ReadFile(planJSONPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan JSON: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(planJSON))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cost estimation request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling cost estimation endpoint: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cost estimation endpoint returned status %d", resp.StatusCode)
+	}
+
+	var report Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("error decoding cost estimation response: %s", err)
+	}
+	return &report, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/terraform/backend"
@@ -13,6 +14,88 @@ import (
 	"github.com/hashicorp/terraform/terraform"
 )
 
+// leaseRenewInterval is how often the lease-renewing locker refreshes the
+// Azure blob lease backing a long-running operation's state lock. It must
+// stay comfortably under the lease's duration (see leaseDurationSeconds in
+// client.go and remote/account/blob/blob.go) so a renewal always lands
+// before the lease would otherwise expire.
+const leaseRenewInterval = 30 * time.Second
+
+// leaseRenewFailureThreshold is how many consecutive renewal failures
+// leaseRenewingLocker tolerates before canceling the operation. A single
+// failure is usually just a transient blip over a flaky connection; only a
+// run of them means the lease is actually gone (e.g. force-unlocked by
+// another actor).
+const leaseRenewFailureThreshold = 3
+
+// leaseRenewer is implemented by state.State values whose lock is backed by
+// a renewable Azure blob lease.
+type leaseRenewer interface {
+	RenewLease() error
+}
+
+// leaseRenewingLocker wraps a clistate.Locker so that, once it successfully
+// acquires the lock, a background goroutine renews the underlying blob
+// lease every leaseRenewInterval until runningCtx is done. Plan and apply
+// on large states can easily outlive a single lease period; without this,
+// the lease would expire mid-operation and a concurrent run could acquire
+// it out from under us. A renewal can fail transiently (a flaky connection
+// to the storage account), so the operation is only canceled once
+// leaseRenewFailureThreshold renewals in a row have failed — at that point
+// the lease is most likely actually gone, e.g. because another actor
+// force-unlocked the state.
+type leaseRenewingLocker struct {
+	clistate.Locker
+
+	b          *Backend
+	runningCtx context.Context
+	cancel     context.CancelFunc
+}
+
+func (l *leaseRenewingLocker) Lock(s state.State, reason string) error {
+	if err := l.Locker.Lock(s, reason); err != nil {
+		return err
+	}
+
+	renewer, ok := s.(leaseRenewer)
+	if !ok {
+		// The locked state isn't backed by a renewable Azure blob lease
+		// (e.g. the legacy blob-lease backend); nothing to renew.
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		var failures int
+		for {
+			select {
+			case <-l.runningCtx.Done():
+				return
+			case <-ticker.C:
+				if err := renewer.RenewLease(); err != nil {
+					failures++
+					if l.b.CLI != nil {
+						l.b.CLI.Error(l.b.Colorize().Color(fmt.Sprintf(
+							"[reset][bold][red]Error: failed to renew the state lock (%s) [%d/%d].[reset]", err, failures, leaseRenewFailureThreshold)))
+					}
+					if failures < leaseRenewFailureThreshold {
+						continue
+					}
+					if l.b.CLI != nil {
+						l.b.CLI.Error(l.b.Colorize().Color(
+							"[reset][bold][red]Error: lost the state lock. Another actor may have force-unlocked it; canceling the operation.[reset]"))
+					}
+					l.cancel()
+					return
+				}
+				failures = 0
+			}
+		}
+	}()
+	return nil
+}
+
 // Operation TODO!
 func (b *Backend) Operation(ctx context.Context, op *backend.Operation) (*backend.RunningOperation, error) {
 	var f func(context.Context, context.Context, *backend.Operation, *backend.RunningOperation)
@@ -40,7 +123,12 @@ func (b *Backend) Operation(ctx context.Context, op *backend.Operation) (*backen
 	runningOp.Cancel = cancel
 
 	if op.LockState {
-		op.StateLocker = clistate.NewLocker(stopCtx, op.StateLockTimeout, b.CLI, b.Colorize())
+		op.StateLocker = &leaseRenewingLocker{
+			Locker:     clistate.NewLocker(stopCtx, op.StateLockTimeout, b.CLI, b.Colorize()),
+			b:          b,
+			runningCtx: runningCtx,
+			cancel:     cancel,
+		}
 	} else {
 		op.StateLocker = clistate.NewNoopLocker()
 	}
@@ -152,12 +240,5 @@ func (b *Backend) refresh(stopCtx context.Context, cancelCtx context.Context, op
 	}
 }
 
-// terraform plan
-func (b *Backend) plan(stopCtx context.Context, cancelCtx context.Context, op *backend.Operation, runningOp *backend.RunningOperation) {
-	panic("todo")
-}
-
-// terraform apply
-func (b *Backend) apply(stopCtx context.Context, cancelCtx context.Context, op *backend.Operation, runningOp *backend.RunningOperation) {
-	panic("todo")
-}
+// plan and apply are implemented in plan.go and apply.go, respectively,
+// following the same goroutine/b.wait pattern as refresh above.
@@ -3,14 +3,21 @@ package secureazurerm
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 
 	"github.com/hashicorp/terraform/backend"
 	"github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/cost"
 	"github.com/hashicorp/terraform/command/format"
 	"github.com/hashicorp/terraform/config/module"
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform/version"
 )
 
 // plan performs "terraform plan"
@@ -73,9 +80,138 @@ func (b *Backend) plan(stopCtx context.Context, cancelCtx context.Context, op *b
 			b.CLI.Output("\n" + b.Colorize().Color(strings.TrimSpace(planNoChanges)))
 			return
 		}
-		b.render(dispPlan)
+
+		var costReport *cost.Report
+		if b.costEstimator != nil {
+			var err error
+			if costReport, err = b.estimateCost(op.Workspace, plan); err != nil {
+				// Cost estimation is a nice-to-have, so warn rather than fail the plan.
+				b.CLI.Error(fmt.Sprintf("Warning: error estimating cost: %s", err))
+			}
+		}
+
+		b.render(dispPlan, costReport)
 		b.CLI.Output(fmt.Sprintf("\n" + strings.TrimSpace(noGuaranteeMsg) + "\n"))
 	}
+
+	if op.PlanOutPath != "" {
+		if err := b.uploadPlanArtifact(op, opState, plan); err != nil {
+			runningOp.Err = fmt.Errorf("error uploading remote plan artifact: %s", err)
+			return
+		}
+	}
+}
+
+// estimateCost writes the plan to a temporary file in Terraform's JSON plan
+// format and invokes the configured cost.Estimator against it. The report is
+// also persisted as a Key Vault secret in workspace's state key vault, keyed
+// by workspace and planHash, so cost estimates survive for historical audit
+// alongside the state they were computed against.
+func (b *Backend) estimateCost(workspace string, plan *terraform.Plan) (*cost.Report, error) {
+	planJSONBytes, err := json.Marshal(planJSON(plan))
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling plan JSON: %s", err)
+	}
+
+	f, err := ioutil.TempFile("", "secureazurerm-plan-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary plan file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(planJSONBytes); err != nil {
+		return nil, fmt.Errorf("error writing plan JSON: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("error closing temporary plan file: %s", err)
+	}
+
+	report, err := b.costEstimator.Estimate(context.Background(), f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error running cost estimator: %s", err)
+	}
+
+	if err := b.persistCostReport(workspace, planHash(planJSONBytes), report); err != nil {
+		// Persisting the report for audit is a nice-to-have alongside the
+		// estimate itself, so warn rather than fail the plan/apply over it.
+		if b.CLI != nil {
+			b.CLI.Error(fmt.Sprintf("Warning: error persisting cost report: %s", err))
+		}
+	}
+
+	return report, nil
+}
+
+// persistCostReport writes report as a Key Vault secret in workspace's state
+// key vault, named after planHash so every estimate computed against a given
+// plan gets its own secret rather than overwriting the previous one.
+func (b *Backend) persistCostReport(workspace, planHash string, report *cost.Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("error marshalling cost report: %s", err)
+	}
+	keyVault, err := b.setupKeyVault(workspace)
+	if err != nil {
+		return fmt.Errorf("error setting up state key vault: %s", err)
+	}
+	tags := map[string]*string{"workspace": &workspace, "plan_hash": &planHash}
+	if _, err := keyVault.SetSecret(context.Background(), costReportSecretName(planHash), string(data), tags); err != nil {
+		return fmt.Errorf("error setting cost report secret: %s", err)
+	}
+	return nil
+}
+
+// costReportSecretName returns the Key Vault secret name a cost report
+// computed against the plan hashing to planHash is stored under.
+func costReportSecretName(planHash string) string {
+	return fmt.Sprintf("costreport-%s", planHash[:12])
+}
+
+// planHash returns the hex-encoded SHA256 of a plan's JSON representation,
+// used to key persisted cost reports and policy findings to the exact plan
+// that produced them.
+func planHash(planJSON []byte) string {
+	sum := sha256.Sum256(planJSON)
+	return hex.EncodeToString(sum[:])
+}
+
+// planJSON is a minimal Terraform JSON plan representation sufficient for
+// Infracost (or an equivalent pricing API) to price the planned resources.
+func planJSON(plan *terraform.Plan) map[string]interface{} {
+	var resourceChanges []map[string]interface{}
+	for _, moduleDiff := range plan.Diff.Modules {
+		for addr, resourceDiff := range moduleDiff.Resources {
+			resourceChanges = append(resourceChanges, map[string]interface{}{
+				"address": addr,
+				"change": map[string]interface{}{
+					"actions": diffActions(resourceDiff),
+				},
+			})
+		}
+	}
+	return map[string]interface{}{
+		"format_version":    "0.1",
+		"terraform_version": version.Version,
+		"resource_changes":  resourceChanges,
+	}
+}
+
+// diffActions returns the Terraform-JSON-plan-style action verbs for a
+// resource diff (e.g. ["create"], ["delete", "create"]).
+func diffActions(d *terraform.InstanceDiff) []string {
+	switch {
+	case d.Destroy && d.RequiresNew():
+		return []string{"delete", "create"}
+	case d.Destroy:
+		return []string{"delete"}
+	case d.RequiresNew():
+		return []string{"create"}
+	case len(d.Attributes) > 0:
+		return []string{"update"}
+	default:
+		return []string{"no-op"}
+	}
 }
 
 const noGuaranteeMsg = `
@@ -84,7 +220,7 @@ Terraform cannot guarantee that exactly these actions will be performed if
 `
 
 // render renders terraform plan.
-func (b *Backend) render(plan *format.Plan) {
+func (b *Backend) render(plan *format.Plan, costReport *cost.Report) {
 	// Render intro header.
 	header := &bytes.Buffer{}
 	fmt.Fprintf(header, "%s\n", planHeaderIntro)
@@ -121,6 +257,25 @@ func (b *Backend) render(plan *format.Plan) {
 			stats.ToAdd, stats.ToChange, stats.ToDestroy,
 		)))
 	}
+
+	if costReport != nil {
+		b.renderCost(costReport)
+	}
+}
+
+// renderCost prints a "Cost changes" section summarizing the monthly cost
+// delta per resource, alongside the existing add/change/destroy counts.
+func (b *Backend) renderCost(report *cost.Report) {
+	b.CLI.Output(b.Colorize().Color("\n[reset][bold]Cost changes:[reset]\n"))
+	for _, r := range report.Resources {
+		if r.DiffMonthlyCost == 0 {
+			continue
+		}
+		b.CLI.Output(fmt.Sprintf("  %s: %+.2f/mo", r.Name, r.DiffMonthlyCost))
+	}
+	b.CLI.Output(b.Colorize().Color(fmt.Sprintf("[reset][bold]%+.2f/mo[reset] total monthly cost delta (%.2f/mo -> %.2f/mo)",
+		report.DiffTotalMonthlyCost, report.PastTotalMonthlyCost, report.TotalMonthlyCost,
+	)))
 }
 
 const planHeaderIntro = `An execution plan has been generated and is shown below.
@@ -0,0 +1,141 @@
+package secureazurerm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform/version"
+)
+
+// planArtifactSuffix names the sibling blob a workspace's remote plan
+// artifact is stored under, e.g. "default.tfplan".
+const planArtifactSuffix = ".tfplan"
+
+// planArtifact is the masked, remote representation of a plan written to
+// "<workspace>.tfplan" when op.PlanOutPath is set. It is consumed by a
+// later apply to verify the parent state hasn't drifted since the plan was
+// made (see verifyPlanArtifactETag in apply.go).
+type planArtifact struct {
+	FormatVersion    string               `json:"format_version"`
+	TerraformVersion string               `json:"terraform_version"`
+	Workspace        string               `json:"workspace"`
+	ParentStateETag  string               `json:"parent_state_etag"`
+	ResourceChanges  []planResourceChange `json:"resource_changes"`
+}
+
+// planResourceChange is a single resource's diff in a planArtifact.
+// Attributes that are sensitive (as marked on the InstanceDiff) are masked
+// the same way state attributes are, by reference into the workspace's
+// secret store, rather than being written out in plaintext.
+type planResourceChange struct {
+	Address    string                 `json:"address"`
+	Actions    []string               `json:"actions"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// uploadPlanArtifact serializes plan into a planArtifact, masking sensitive
+// attributes through the workspace's secret store, and uploads it as the
+// sibling blob "<workspace>.tfplan" alongside the state blob, tagged with
+// the state's current ETag so a later apply can detect drift.
+//
+// Remote plan artifacts require the key vault-backed state backend; if
+// opState isn't one (e.g. it came from the legacy blob-lease backend), this
+// is a warning rather than a failure, the same as a failed cost estimate.
+func (b *Backend) uploadPlanArtifact(op *backend.Operation, opState state.State, plan *terraform.Plan) error {
+	rs, ok := opState.(*remote.State)
+	if !ok {
+		if b.CLI != nil {
+			b.CLI.Error("Warning: -out is only supported with the key vault-backed state backend; skipping remote plan artifact")
+		}
+		return nil
+	}
+
+	artifact := planArtifact{
+		FormatVersion:    "0.1",
+		TerraformVersion: version.Version,
+		Workspace:        op.Workspace,
+		ParentStateETag:  rs.ETag(),
+	}
+	for _, moduleDiff := range plan.Diff.Modules {
+		for addr, resourceDiff := range moduleDiff.Resources {
+			change := planResourceChange{
+				Address:    addr,
+				Actions:    diffActions(resourceDiff),
+				Attributes: make(map[string]interface{}),
+			}
+			for name, attrDiff := range resourceDiff.Attributes {
+				if attrDiff.Sensitive {
+					secretName := planArtifactSecretName(op.Workspace, addr, name)
+					workspace, attribute := op.Workspace, name
+					tags := map[string]*string{"workspace": &workspace, "resource": &addr, "attribute": &attribute}
+					secretVersion, err := rs.SecretStore.Put(context.Background(), secretName, attrDiff.New, tags)
+					if err != nil {
+						return fmt.Errorf("error masking plan attribute %s.%s: %s", addr, name, err)
+					}
+					change.Attributes[name] = map[string]interface{}{"type": "string", "id": secretName, "version": secretVersion}
+				} else {
+					change.Attributes[name] = map[string]interface{}{"old": attrDiff.Old, "new": attrDiff.New}
+				}
+			}
+			artifact.ResourceChanges = append(artifact.ResourceChanges, change)
+		}
+	}
+
+	data, err := json.MarshalIndent(&artifact, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling plan artifact: %s", err)
+	}
+	data = append(data, '\n')
+
+	artifactBlob, err := rs.Blob.Sibling(op.Workspace + planArtifactSuffix)
+	if err != nil {
+		return fmt.Errorf("error setting up plan artifact blob: %s", err)
+	}
+	if err := artifactBlob.Put(data); err != nil {
+		return fmt.Errorf("error uploading plan artifact: %s", err)
+	}
+	return nil
+}
+
+// planArtifactSecretName derives a stable, filesystem- and URL-safe Key
+// Vault secret name for a masked plan attribute, scoped to the workspace
+// and resource address so re-planning the same resource reuses the name.
+func planArtifactSecretName(workspace, resourceAddr, attribute string) string {
+	r := strings.NewReplacer("/", "-", ".", "-", "[", "-", "]", "")
+	return fmt.Sprintf("planartifact-%s-%s-%s", r.Replace(workspace), r.Replace(resourceAddr), r.Replace(attribute))
+}
+
+// fetchPlanArtifact downloads and parses the "<workspace>.tfplan" sibling
+// blob, if one exists, returning nil, nil when no plan has ever been
+// uploaded for this workspace. Sibling provisions the blob the first time
+// it's referenced, the same as any other workspace blob, so an artifact
+// that was never uploaded reads back as an empty placeholder rather than a
+// missing blob; FormatVersion is only ever set by uploadPlanArtifact, so
+// its absence is what actually distinguishes "nothing uploaded yet".
+func fetchPlanArtifact(rs *remote.State, workspace string) (*planArtifact, error) {
+	artifactBlob, err := rs.Blob.Sibling(workspace + planArtifactSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up plan artifact blob: %s", err)
+	}
+	payload, err := artifactBlob.Get()
+	if err != nil {
+		return nil, fmt.Errorf("error getting plan artifact: %s", err)
+	}
+	if payload == nil {
+		return nil, nil
+	}
+	artifact := &planArtifact{}
+	if err := json.Unmarshal(payload.Data, artifact); err != nil {
+		return nil, fmt.Errorf("error unmarshalling plan artifact: %s", err)
+	}
+	if artifact.FormatVersion == "" {
+		return nil, nil
+	}
+	return artifact, nil
+}
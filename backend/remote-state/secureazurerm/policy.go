@@ -0,0 +1,105 @@
+package secureazurerm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/policy"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// scanPolicy writes the plan to a temporary JSON plan file and runs it
+// through the configured policy.Scanner. The findings are also persisted as
+// a Key Vault secret in workspace's state key vault, keyed by workspace and
+// the plan's hash, for historical audit.
+func (b *Backend) scanPolicy(workspace string, plan *terraform.Plan) (policy.Findings, error) {
+	planJSONBytes, err := json.Marshal(planJSON(plan))
+	if err != nil {
+		return policy.Findings{}, fmt.Errorf("error marshalling plan JSON: %s", err)
+	}
+
+	f, err := ioutil.TempFile("", "secureazurerm-plan-*.json")
+	if err != nil {
+		return policy.Findings{}, fmt.Errorf("error creating temporary plan file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(planJSONBytes); err != nil {
+		return policy.Findings{}, fmt.Errorf("error writing plan JSON: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return policy.Findings{}, fmt.Errorf("error closing temporary plan file: %s", err)
+	}
+
+	findings, err := b.policyScanner.Scan(context.Background(), f.Name())
+	if err != nil {
+		return policy.Findings{}, fmt.Errorf("error running policy scanner: %s", err)
+	}
+
+	if err := b.persistPolicyFindings(workspace, planHash(planJSONBytes), findings); err != nil {
+		// Persisting findings for audit is a nice-to-have alongside the scan
+		// itself, so warn rather than fail the plan/apply over it.
+		if b.CLI != nil {
+			b.CLI.Error(fmt.Sprintf("Warning: error persisting policy findings: %s", err))
+		}
+	}
+
+	return findings, nil
+}
+
+// persistPolicyFindings writes findings as a Key Vault secret in workspace's
+// state key vault, named after planHash so every scan run against a given
+// plan gets its own secret rather than overwriting the previous one.
+func (b *Backend) persistPolicyFindings(workspace, planHash string, findings policy.Findings) error {
+	data, err := json.Marshal(findings)
+	if err != nil {
+		return fmt.Errorf("error marshalling policy findings: %s", err)
+	}
+	keyVault, err := b.setupKeyVault(workspace)
+	if err != nil {
+		return fmt.Errorf("error setting up state key vault: %s", err)
+	}
+	tags := map[string]*string{"workspace": &workspace, "plan_hash": &planHash}
+	if _, err := keyVault.SetSecret(context.Background(), policyFindingsSecretName(planHash), string(data), tags); err != nil {
+		return fmt.Errorf("error setting policy findings secret: %s", err)
+	}
+	return nil
+}
+
+// policyFindingsSecretName returns the Key Vault secret name policy findings
+// computed against the plan hashing to planHash are stored under.
+func policyFindingsSecretName(planHash string) string {
+	return fmt.Sprintf("policyfindings-%s", planHash[:12])
+}
+
+// renderPolicyFindings prints a findings summary before the confirmation prompt.
+func (b *Backend) renderPolicyFindings(findings policy.Findings) {
+	if b.CLI == nil {
+		return
+	}
+	all := findings.All()
+	if len(all) == 0 {
+		return
+	}
+	b.CLI.Output(b.Colorize().Color("\n[reset][bold]Policy scan findings:[reset]\n"))
+	for _, f := range all {
+		b.CLI.Output(fmt.Sprintf("  [%s] %s on %s: %s", severityLabel(f.Severity), f.RuleID, f.ResourceAddress, f.Description))
+	}
+}
+
+func severityLabel(s policy.Severity) string {
+	switch s {
+	case policy.Critical:
+		return "CRITICAL"
+	case policy.High:
+		return "HIGH"
+	case policy.Medium:
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
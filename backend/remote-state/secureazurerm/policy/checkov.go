@@ -0,0 +1,71 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// CheckovScanner runs `checkov -f <plan.json> --output json` against the plan.
+type CheckovScanner struct {
+	// BinaryPath is the path to the checkov binary. Defaults to "checkov".
+	BinaryPath string
+	// SkipChecks lists check IDs to skip (passed as --skip-check).
+	SkipChecks []string
+}
+
+type checkovCheck struct {
+	CheckID     string `json:"check_id"`
+	CheckResult struct {
+		Result string `json:"result"`
+	} `json:"check_result"`
+	Severity     string `json:"severity"`
+	Resource     string `json:"resource"`
+	GuidelineURL string `json:"guideline"`
+}
+
+type checkovOutput struct {
+	Results struct {
+		FailedChecks []checkovCheck `json:"failed_checks"`
+	} `json:"results"`
+}
+
+// Scan implements Scanner.
+func (s *CheckovScanner) Scan(ctx context.Context, planJSONPath string) (Findings, error) {
+	bin := s.BinaryPath
+	if bin == "" {
+		bin = "checkov"
+	}
+
+	args := []string{"-f", planJSONPath, "--output", "json"}
+	for _, check := range s.SkipChecks {
+		args = append(args, "--skip-check", check)
+	}
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// checkov exits non-zero when it finds failed checks, so don't treat that
+	// as a hard error - only a truly empty/unparsable output is fatal.
+	_ = cmd.Run()
+
+	var out checkovOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Findings{}, fmt.Errorf("error unmarshalling checkov output: %s: %s", err, stderr.String())
+	}
+
+	var findings Findings
+	for _, check := range out.Results.FailedChecks {
+		findings.add(Finding{
+			RuleID:          check.CheckID,
+			Severity:        ParseSeverity(check.Severity),
+			ResourceAddress: check.Resource,
+			Description:     check.CheckID,
+			RemediationURL:  check.GuidelineURL,
+		})
+	}
+	return findings, nil
+}
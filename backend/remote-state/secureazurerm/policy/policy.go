@@ -0,0 +1,92 @@
+// Package policy runs pre-apply policy scanners (Checkov, tfsec, ...) against
+// a Terraform plan and reports findings grouped by severity.
+package policy
+
+import (
+	"context"
+)
+
+// Severity is the severity level of a policy finding, ordered from least to
+// most severe.
+type Severity int
+
+// Severity levels, ordered so that comparisons like `sev >= threshold` work.
+const (
+	Low Severity = iota
+	Medium
+	High
+	Critical
+)
+
+// ParseSeverity converts a scanner's severity string into a Severity.
+// Unrecognized values are treated as Low so a misconfigured fail_on_severity
+// fails open rather than blocking every apply.
+func ParseSeverity(s string) Severity {
+	switch s {
+	case "CRITICAL":
+		return Critical
+	case "HIGH":
+		return High
+	case "MEDIUM":
+		return Medium
+	default:
+		return Low
+	}
+}
+
+// Finding is a single policy violation reported by a scanner.
+type Finding struct {
+	RuleID          string
+	Severity        Severity
+	ResourceAddress string
+	Description     string
+	RemediationURL  string
+}
+
+// Findings groups findings by severity.
+type Findings struct {
+	Critical []Finding
+	High     []Finding
+	Medium   []Finding
+	Low      []Finding
+}
+
+// All returns every finding across all severities.
+func (f Findings) All() []Finding {
+	all := make([]Finding, 0, len(f.Critical)+len(f.High)+len(f.Medium)+len(f.Low))
+	all = append(all, f.Critical...)
+	all = append(all, f.High...)
+	all = append(all, f.Medium...)
+	all = append(all, f.Low...)
+	return all
+}
+
+// MeetsOrExceeds reports whether any finding in f is at least as severe as
+// threshold.
+func (f Findings) MeetsOrExceeds(threshold Severity) bool {
+	for _, finding := range f.All() {
+		if finding.Severity >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends a finding to the correct severity bucket.
+func (f *Findings) add(finding Finding) {
+	switch finding.Severity {
+	case Critical:
+		f.Critical = append(f.Critical, finding)
+	case High:
+		f.High = append(f.High, finding)
+	case Medium:
+		f.Medium = append(f.Medium, finding)
+	default:
+		f.Low = append(f.Low, finding)
+	}
+}
+
+// Scanner scans a Terraform JSON plan for policy violations.
+type Scanner interface {
+	Scan(ctx context.Context, planJSONPath string) (Findings, error)
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// TfsecScanner runs `tfsec --format json` against the plan.
+type TfsecScanner struct {
+	// BinaryPath is the path to the tfsec binary. Defaults to "tfsec".
+	BinaryPath string
+	// SkipChecks lists rule IDs to skip (passed as --exclude).
+	SkipChecks []string
+}
+
+type tfsecResult struct {
+	RuleID      string   `json:"rule_id"`
+	Severity    string   `json:"severity"`
+	Resource    string   `json:"resource"`
+	Description string   `json:"description"`
+	Links       []string `json:"links"`
+}
+
+type tfsecOutput struct {
+	Results []tfsecResult `json:"results"`
+}
+
+// Scan implements Scanner.
+func (s *TfsecScanner) Scan(ctx context.Context, planJSONPath string) (Findings, error) {
+	bin := s.BinaryPath
+	if bin == "" {
+		bin = "tfsec"
+	}
+
+	args := []string{planJSONPath, "--format", "json"}
+	if len(s.SkipChecks) > 0 {
+		exclude := s.SkipChecks[0]
+		for _, check := range s.SkipChecks[1:] {
+			exclude += "," + check
+		}
+		args = append(args, "--exclude", exclude)
+	}
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// tfsec exits non-zero when it finds violations; only a missing/unparsable
+	// report is a hard failure.
+	_ = cmd.Run()
+
+	var out tfsecOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Findings{}, fmt.Errorf("error unmarshalling tfsec output: %s: %s", err, stderr.String())
+	}
+
+	var findings Findings
+	for _, result := range out.Results {
+		var remediationURL string
+		if len(result.Links) > 0 {
+			remediationURL = result.Links[0]
+		}
+		findings.add(Finding{
+			RuleID:          result.RuleID,
+			Severity:        ParseSeverity(result.Severity),
+			ResourceAddress: result.Resource,
+			Description:     result.Description,
+			RemediationURL:  remediationURL,
+		})
+	}
+	return findings, nil
+}
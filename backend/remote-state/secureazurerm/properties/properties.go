@@ -2,6 +2,7 @@ package properties
 
 import (
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
 )
 
 // Properties describes the properties of the state resource group.
@@ -9,11 +10,168 @@ type Properties struct {
 	// State resource group properties.
 	ResourceGroupName,
 	Location,
-	KeyVaultPrefix,
 	SubscriptionID,
 	TenantID,
 	ObjectID string
 
+	// KeyVaultPrefix names the per-workspace key vaults keyvault.Setup
+	// creates: the vault for workspace w is named KeyVaultPrefix+w.
+	KeyVaultPrefix string
+
 	// Authorizers and clients.
 	MgmtAuthorizer autorest.Authorizer
+
+	// EncryptionKeyName, when set, is the name of the Key Vault key used to
+	// envelope-encrypt the state blob's contents client-side, on top of
+	// whatever server-side encryption the storage account provides.
+	// EncryptionKeyVersion pins reads/writes to a specific key version;
+	// empty resolves to the key's current version.
+	EncryptionKeyName    string
+	EncryptionKeyVersion string
+
+	// EncryptionKeyBase64, when set, is a base64-encoded 32-byte AES-256 key
+	// used as the envelope encryption DEK directly instead of one generated
+	// per write and wrapped through Key Vault — a customer-provided-key mode
+	// for operators who want the DEK to never touch Key Vault at all.
+	// Mutually exclusive with EncryptionKeyName; backend.go enforces that.
+	EncryptionKeyBase64 string
+
+	// KeyVaultSKU overrides the SKU ("standard" or "premium") a newly
+	// created vault is provisioned with. Empty defaults to "standard".
+	KeyVaultSKU string
+	// SoftDeleteRetentionDays overrides how many days a newly created
+	// vault's soft-deleted tombstones are retained for. 0 defaults to 90.
+	SoftDeleteRetentionDays int32
+	// PurgeProtection overrides whether a newly created vault has purge
+	// protection enabled. nil defaults to true.
+	PurgeProtection *bool
+
+	// BlobSoftDeleteRetentionDays is how many days a newly created storage
+	// account retains soft-deleted blobs and blob versions for. 0 defaults
+	// to 90.
+	BlobSoftDeleteRetentionDays int32
+
+	// AccountTier and AccountReplication combine into the SKU (e.g.
+	// "Standard_LRS") a newly created storage account uses. AccountKind is
+	// the account kind to create ("StorageV2" or "BlobStorage"); AccessTier
+	// is required when AccountKind is "BlobStorage" and ignored otherwise.
+	// account.Setup rejects AccountKind "BlobStorage" combined with
+	// AccountReplication "ZRS"/"GZRS", which BlobStorage accounts don't
+	// support.
+	AccountTier        string
+	AccountReplication string
+	AccountKind        string
+	AccessTier         string
+	// AccountSKU, when set, overrides the SKU account.Setup derives from
+	// AccountTier and AccountReplication and is used as-is instead (e.g.
+	// "Standard_LRS"). Set by the bootstrap {} block, which takes a single
+	// combined SKU rather than separate tier/replication fields.
+	AccountSKU string
+	// RequireTLS12 makes account.Setup create the storage account with
+	// MinimumTLSVersion pinned to TLS 1.2, rejecting older clients. Set by
+	// the bootstrap {} block as part of its hardened defaults; false leaves
+	// the account on the service's own default minimum.
+	RequireTLS12 bool
+	// EnableChangeFeed turns on the storage account's blob change feed,
+	// which records every write/delete to a separate, append-only log
+	// container for external auditing/replay. Off by default since it adds
+	// ongoing storage cost.
+	EnableChangeFeed bool
+
+	// StorageAccountName, when set, makes account.Setup use this
+	// pre-existing storage account as-is instead of auto-provisioning one
+	// named after the resource group — bring-your-own-account for resource
+	// groups shared with other apps. StorageAccountResourceGroup is the
+	// resource group the account lives in; empty defaults to
+	// ResourceGroupName (the state resource group). StorageAccountResourceID
+	// is filled in by account.Setup with the resolved account's resource ID.
+	StorageAccountName          string
+	StorageAccountResourceGroup string
+	StorageAccountResourceID    string
+
+	// StorageCMKVaultURI, StorageCMKKeyName, and StorageCMKKeyVersion
+	// identify the Key Vault key account.Setup switches the storage
+	// account's encryption to (KeySource=Microsoft.Keyvault), instead of
+	// leaving it on the Microsoft-managed default. Empty StorageCMKVaultURI
+	// disables CMK entirely. StorageCMKUserAssignedIdentityID, when set, is
+	// the resource ID of the user-assigned identity the account
+	// authenticates to the vault with; empty has account.Setup assign and
+	// use a system-assigned identity instead.
+	StorageCMKVaultURI               string
+	StorageCMKKeyName                string
+	StorageCMKKeyVersion             string
+	StorageCMKUserAssignedIdentityID string
+
+	// NetworkAllowedSubnetIDs and NetworkAllowedIPRanges allow-list specific
+	// subnets and IP ranges through a newly created storage account's
+	// firewall; when either is non-empty, account.Setup sets the new
+	// account's NetworkRuleSet to DefaultAction=Deny, Bypass=AzureServices.
+	// Both empty leaves a new account open to the public internet (the
+	// pre-existing default). ClientIP, when set, is allow-listed alongside
+	// them instead of account.Setup detecting its own egress IP, for
+	// callers that already know it or run somewhere IP detection won't
+	// reach the internet from.
+	NetworkAllowedSubnetIDs []string
+	NetworkAllowedIPRanges  []string
+	ClientIP                string
+
+	// PrivateEndpointSubnetID, when set, makes account.Setup provision a
+	// Private Endpoint for the storage account's blob sub-resource into
+	// this subnet. PrivateDNSZoneID, when also set, links the endpoint to
+	// that Private DNS Zone via a DNS zone group entry, so data-plane
+	// traffic resolves to the endpoint's private IP automatically.
+	PrivateEndpointSubnetID string
+	PrivateDNSZoneID        string
+
+	// UseAzureADAuth, when true, makes account.Setup authorize blob
+	// data-plane requests with StorageToken instead of a fetched storage
+	// account access key, so ListKeys is never called and no long-lived key
+	// is held in memory. Requires the caller's principal to have the
+	// Storage Blob Data Contributor role (or better) on the account.
+	UseAzureADAuth bool
+	// StorageToken is the Azure AD token used to authorize blob data-plane
+	// requests when UseAzureADAuth is true. Ignored otherwise.
+	StorageToken *adal.ServicePrincipalToken
+
+	// SASToken, when set, authorizes blob data-plane requests with this
+	// account SAS query string instead of a shared key or Azure AD token —
+	// for CI systems that should hold a narrowly-scoped, expiring credential
+	// rather than the full account key. Takes priority over UseAzureADAuth
+	// and the storage account key if both are also somehow set.
+	SASToken string
+
+	// AuditContainerName, when set, makes StateMgr record every
+	// Put/Delete/Lock/Unlock against a workspace's state blob to an
+	// append-blob audit log kept in this separate container, instead of
+	// skipping audit logging entirely. A dedicated container (rather than
+	// the state container itself) is what lets AuditImmutabilityDays and
+	// AuditLegalHold lock the log down without also making the state blob
+	// itself immutable.
+	AuditContainerName string
+	// AuditImmutabilityDays, when set, makes account.SetupAuditContainer
+	// apply a time-based retention immutability policy to the audit
+	// container for this many days, with protected append writes allowed so
+	// new entries can still be appended. 0 disables it.
+	AuditImmutabilityDays int32
+	// AuditLegalHold, when true, makes account.SetupAuditContainer place a
+	// legal hold on the audit container, blocking deletion of any blob in it
+	// — including by the very principal that wrote the state it describes —
+	// until the hold is lifted.
+	AuditLegalHold bool
+
+	// AccessPolicies declares which resources' managed identities should be
+	// granted access to the state key vault. remote.State.PersistState
+	// resolves each entry's current principal_id/tenant_id out of the state
+	// and reconciles the vault's access policies to match, every apply.
+	AccessPolicies []AccessPolicyConfig
+}
+
+// AccessPolicyConfig grants the managed identity of the resource at
+// ResourceAddress (e.g. "azurerm_linux_virtual_machine.app", or
+// "module.foo.azurerm_kubernetes_cluster.main" for a resource inside a
+// module) the listed secret Permissions ("get", "list", "set", "delete")
+// on the state key vault.
+type AccessPolicyConfig struct {
+	ResourceAddress string
+	Permissions     []string
 }
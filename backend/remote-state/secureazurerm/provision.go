@@ -0,0 +1,43 @@
+package secureazurerm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/auth"
+)
+
+// provisionInfra ensures the resource group named in c exists, creating it
+// unless c.Provision is false, in which case a missing resource group is a
+// configuration error instead of being created automatically. The storage
+// account and container are provisioned separately, by account.Setup (see
+// states.go), once the resource group exists.
+func (b *Backend) provisionInfra(ctx context.Context, c config, creds *auth.Credentials) error {
+	groupsClient := resources.NewGroupsClient(c.SubscriptionID)
+	groupsClient.Authorizer = creds.MgmtAuthorizer
+
+	location := c.Location
+	if c.Bootstrap != nil && c.Bootstrap.Location != "" {
+		location = c.Bootstrap.Location
+	}
+
+	exists, err := groupsClient.CheckExistence(ctx, c.ResourceGroupName)
+	if err != nil {
+		return fmt.Errorf("error checking resource group %q: %s", c.ResourceGroupName, err)
+	}
+	if exists.StatusCode == 404 {
+		if !c.Provision && c.Bootstrap == nil {
+			return fmt.Errorf("resource group %q does not exist and neither provision nor bootstrap is set", c.ResourceGroupName)
+		}
+		if _, err := groupsClient.CreateOrUpdate(ctx, c.ResourceGroupName, resources.Group{
+			Location: to.StringPtr(location),
+		}); err != nil {
+			return fmt.Errorf("error creating resource group %q: %s", c.ResourceGroupName, err)
+		}
+	}
+
+	return nil
+}
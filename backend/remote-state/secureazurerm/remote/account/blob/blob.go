@@ -1,15 +1,25 @@
 package blob
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/common"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/retry"
 	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/version"
@@ -23,14 +33,50 @@ type Blob struct {
 	// Blob info:
 	Name    string // The name of the blob that stores the remote state in JSON. Should be equal to workspace-name.
 	leaseID string // The lease ID used as a lock/mutex to the blob.
+	etag    string // The ETag observed on the last successful Get or Put, used for optimistic concurrency checks.
+
+	// SnapshotTags, when set, is attached as metadata to every snapshot a
+	// Put/PutWithMetadata/PutIfMatch call creates when it rolls the blob's
+	// previous body off into one, so ListSnapshots can report the
+	// provenance (e.g. "principal" and "run_id") of the apply that produced
+	// it. Nil attaches no extra metadata, same as before this field existed.
+	SnapshotTags map[string]string
+
+	// auditContainer, when set via SetAuditContainer, is where
+	// appendAuditEntry/ReadAuditLog keep the blob's audit log instead of
+	// container, so the log can be locked down with an immutability policy
+	// independently of the (necessarily mutable) state container.
+	auditContainer *account.Container
+
+	retryPolicy retry.Policy // governs how data-plane calls below retry transient Azure errors.
+}
+
+// SetAuditContainer points the blob's audit log at a separate container
+// from the one holding the state blob itself, so the two can have
+// different lifecycle/immutability policies. Unset (the default), the
+// audit log lives alongside the state blob in container.
+func (b *Blob) SetAuditContainer(c *account.Container) {
+	b.auditContainer = c
+}
+
+// auditLogContainer returns the container the blob's audit log lives in:
+// b.auditContainer if SetAuditContainer was called, otherwise the same
+// container as the state blob.
+func (b *Blob) auditLogContainer() *account.Container {
+	if b.auditContainer != nil {
+		return b.auditContainer
+	}
+	return b.container
 }
 
-// Setup setups a new or existing blob.
-func Setup(container *account.Container, name string) (*Blob, error) {
+// Setup setups a new or existing blob, retrying transient data-plane errors
+// according to retryPolicy.
+func Setup(container *account.Container, name string, retryPolicy retry.Policy) (*Blob, error) {
 	// Initialize blob.
 	blob := Blob{
-		container: container,
-		Name:      name,
+		container:   container,
+		Name:        name,
+		retryPolicy: retryPolicy,
 	}
 
 	// Check if blob exists.
@@ -91,9 +137,12 @@ func (b *Blob) Get() (payload *remote.Payload, returnErr error) {
 		return nil, fmt.Errorf("blob does not exist")
 	}
 
-	// Get remote state from blob.
-	data, err := blob.Get(&storage.GetBlobOptions{})
-	if err != nil {
+	// Get remote state from blob, retrying transient failures.
+	var data io.ReadCloser
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() (err error) {
+		data, err = blob.Get(&storage.GetBlobOptions{})
+		return err
+	}); err != nil {
 		if storErr, ok := err.(storage.AzureStorageServiceError); ok {
 			return nil, fmt.Errorf(storErr.Code)
 		}
@@ -111,16 +160,122 @@ func (b *Blob) Get() (payload *remote.Payload, returnErr error) {
 	if _, err := io.Copy(&buf, data); err != nil {
 		return nil, fmt.Errorf("failed to read remote state: %s", err)
 	}
+	// Verify against the whole-blob MD5 commitBlocks stored in the blob's
+	// properties, if present, so a corrupted download is caught here rather
+	// than surfacing as a confusing JSON-decode error downstream. Blobs
+	// written before staged uploads existed have no ContentMD5 and skip
+	// this check.
+	if blob.Properties.ContentMD5 != "" {
+		sum := md5.Sum(buf.Bytes())
+		if base64.StdEncoding.EncodeToString(sum[:]) != blob.Properties.ContentMD5 {
+			return nil, fmt.Errorf("state blob failed MD5 integrity check; it may have been corrupted in transit")
+		}
+	}
+
 	// Make payload from remote state blob data.
 	payload = &remote.Payload{Data: buf.Bytes()}
 	if len(payload.Data) == 0 {
 		return nil, nil
 	}
+	b.etag = blob.Properties.Etag
 	return payload, nil
 }
 
 // Put puts data into the blob.
 func (b *Blob) Put(data []byte) error {
+	return b.PutWithMetadata(data, nil)
+}
+
+// blockSizeBytes is the size of each block Put stages before committing,
+// chosen as a balance between parallelism and per-block request overhead.
+// Azure's service maximum is far larger; this package has no need to go
+// anywhere near it.
+const blockSizeBytes = 4 * 1024 * 1024
+
+// uploadConcurrency bounds how many blocks are staged at once, so a very
+// large state file doesn't open unbounded concurrent connections to Azure.
+const uploadConcurrency = 4
+
+// blockID derives the staged block ID for the i'th block of an upload. IDs
+// must sort the same way blocks are meant to be assembled, which a
+// zero-padded decimal (encoded, since block IDs are opaque base64 strings
+// to the service) guarantees up to far more blocks than a state blob will
+// ever have.
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%010d", i)))
+}
+
+// stageBlocks splits data into blockSizeBytes blocks and uploads each with
+// PutBlock, up to uploadConcurrency at a time, sending a per-block MD5 as
+// Content-MD5 so Azure rejects a corrupted upload before any of it is
+// committed. It returns the ordered block list commitBlocks needs to
+// assemble them into the blob. An empty data stages (and commits) as a
+// single empty block, rather than zero blocks, since PutBlockList requires
+// at least one.
+func stageBlocks(blob *storage.Blob, data []byte, retryPolicy retry.Policy, leaseID string) ([]storage.Block, error) {
+	var bounds [][2]int
+	for offset := 0; offset < len(data); offset += blockSizeBytes {
+		end := offset + blockSizeBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		bounds = append(bounds, [2]int{offset, end})
+	}
+	if len(bounds) == 0 {
+		bounds = [][2]int{{0, 0}}
+	}
+
+	blocks := make([]storage.Block, len(bounds))
+	sem := make(chan struct{}, uploadConcurrency)
+	var wg sync.WaitGroup
+	errs := make([]error, len(bounds))
+	for i, bound := range bounds {
+		i, bound := i, bound
+		id := blockID(i)
+		blocks[i] = storage.Block{ID: id, Status: storage.BlockStatusLatest}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			chunk := data[bound[0]:bound[1]]
+			sum := md5.Sum(chunk)
+			errs[i] = retry.Do(retryPolicy, retry.AzureTransient, func() error {
+				return blob.PutBlock(id, chunk, &storage.PutBlockOptions{
+					LeaseID:    leaseID,
+					ContentMD5: base64.StdEncoding.EncodeToString(sum[:]),
+				})
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error staging block %d: %s", i, err)
+		}
+	}
+	return blocks, nil
+}
+
+// commitBlocks assembles blocks (as staged by stageBlocks) into the blob via
+// PutBlockList, storing the whole-payload MD5 in the blob's properties so
+// Get can verify integrity end-to-end. opts carries whichever conditional
+// headers the caller needs applied to the commit, since that's the request
+// that actually makes the new content visible.
+func commitBlocks(blob *storage.Blob, data []byte, blocks []storage.Block, opts *storage.PutBlockListOptions) error {
+	sum := md5.Sum(data)
+	blob.Properties.ContentMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	return blob.PutBlockList(blocks, opts)
+}
+
+// PutWithMetadata behaves like Put, but additionally merges metadata into
+// the blob's user metadata instead of replacing it, preserving whatever's
+// already there (such as lock info) alongside it. Envelope encryption uses
+// this to carry the wrapped DEK, IV, and key ID alongside the ciphertext
+// body instead of folding them into the encrypted payload itself.
+func (b *Blob) PutWithMetadata(data []byte, metadata map[string]string) error {
 	// Check if client's fields are set correctly.
 	if err := b.isValid(); err != nil {
 		return fmt.Errorf("blob is invalid: %s", err)
@@ -137,23 +292,180 @@ func (b *Blob) Put(data []byte) error {
 		if err := b.isLeased(); err != nil {
 			return fmt.Errorf("no lease on blob: %s", err)
 		}
-		// Create a new snapshot of the existing remote state blob.
-		blob.CreateSnapshot(&storage.SnapshotOptions{})
+		// Create a new snapshot of the existing remote state blob, tagged
+		// with SnapshotTags so ListSnapshots can report who/what caused it.
+		if _, err := blob.CreateSnapshot(&storage.SnapshotOptions{LeaseID: b.leaseID, Meta: b.SnapshotTags}); err != nil {
+			return fmt.Errorf("error snapshotting blob: %s", err)
+		}
 		// Get the existing blob's metadata, which will be re-used in the new block blob that replaces the old one.
 		if err := blob.GetMetadata(&storage.GetBlobMetadataOptions{LeaseID: b.leaseID}); err != nil {
 			return fmt.Errorf("error getting metadata: %s", err)
 		}
 	}
+	if blob.Metadata == nil {
+		blob.Metadata = make(map[string]string)
+	}
+	for k, v := range metadata {
+		blob.Metadata[k] = v
+	}
 
 	// Set the blob's properties.
 	blob.Properties.ContentType = "application/json"
 	blob.Properties.ContentLength = int64(len(data))
 
-	// Create a block blob that replaces the old one and upload the remote state in JSON to the blob.
-	if err = blob.CreateBlockBlobFromReader(bytes.NewReader(data), &storage.PutBlobOptions{LeaseID: b.leaseID}); err != nil {
-		return fmt.Errorf("error creating block blob: %s", err)
+	// Stage the remote state in JSON as blocks and commit them, replacing
+	// the old blob, instead of a single-shot upload, so a large state file
+	// uploads in parallel and is verified end-to-end by its MD5.
+	blocks, err := stageBlocks(blob, data, b.retryPolicy, b.leaseID)
+	if err != nil {
+		return fmt.Errorf("error staging block blob: %s", err)
+	}
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return commitBlocks(blob, data, blocks, &storage.PutBlockListOptions{LeaseID: b.leaseID})
+	}); err != nil {
+		return fmt.Errorf("error committing block blob: %s", err)
 	}
-	return blob.SetProperties(&storage.SetBlobPropertiesOptions{LeaseID: b.leaseID})
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blob.SetProperties(&storage.SetBlobPropertiesOptions{LeaseID: b.leaseID})
+	}); err != nil {
+		return err
+	}
+	b.etag = blob.Properties.Etag
+	// Best-effort: the blob write above already succeeded, so a transient
+	// failure recording it to the audit log shouldn't be reported as a
+	// failure of the Put itself.
+	if err := b.appendAuditEntry("Put", data); err != nil {
+		log.Printf("[WARN] error recording audit entry: %s", err)
+	}
+	return nil
+}
+
+// PutIfMatch behaves like Put, but fails instead of overwriting if the
+// blob's ETag has changed since etag was observed ("" requires that the
+// blob not exist yet). PersistState uses this for the manifest write, on
+// top of the lease it already holds, to satisfy the same contract a
+// compare-and-swap would: a manifest that somehow changed since it was
+// last read is never silently clobbered.
+func (b *Blob) PutIfMatch(data []byte, etag string) error {
+	if err := b.isValid(); err != nil {
+		return fmt.Errorf("blob is invalid: %s", err)
+	}
+	blob := b.container.GetBlob(b.Name)
+
+	blobExists, err := blob.Exists()
+	if err != nil {
+		return fmt.Errorf("error checking existence of blob: %s", err)
+	}
+	if blobExists {
+		if err := b.isLeased(); err != nil {
+			return fmt.Errorf("no lease on blob: %s", err)
+		}
+		if _, err := blob.CreateSnapshot(&storage.SnapshotOptions{LeaseID: b.leaseID, Meta: b.SnapshotTags}); err != nil {
+			return fmt.Errorf("error snapshotting blob: %s", err)
+		}
+		if err := blob.GetMetadata(&storage.GetBlobMetadataOptions{LeaseID: b.leaseID}); err != nil {
+			return fmt.Errorf("error getting metadata: %s", err)
+		}
+	}
+
+	blob.Properties.ContentType = "application/json"
+	blob.Properties.ContentLength = int64(len(data))
+
+	// Staging blocks doesn't affect the blob's visible content, so it needs
+	// no ETag condition; only the commit below, which does, carries one.
+	blocks, err := stageBlocks(blob, data, b.retryPolicy, b.leaseID)
+	if err != nil {
+		return fmt.Errorf("error staging block blob: %s", err)
+	}
+
+	opts := &storage.PutBlockListOptions{LeaseID: b.leaseID}
+	if etag == "" {
+		opts.IfNoneMatch = "*"
+	} else {
+		opts.IfMatch = etag
+	}
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return commitBlocks(blob, data, blocks, opts)
+	}); err != nil {
+		return fmt.Errorf("error committing block blob: %s", err)
+	}
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blob.SetProperties(&storage.SetBlobPropertiesOptions{LeaseID: b.leaseID})
+	}); err != nil {
+		return err
+	}
+	b.etag = blob.Properties.Etag
+	// Best-effort: the blob write above already succeeded, so a transient
+	// failure recording it to the audit log shouldn't be reported as a
+	// failure of the Put itself.
+	if err := b.appendAuditEntry("Put", data); err != nil {
+		log.Printf("[WARN] error recording audit entry: %s", err)
+	}
+	return nil
+}
+
+// chunkBlobName returns the content-addressed blob name a chunk is stored
+// under, namespaced under the container so it can't collide with a
+// workspace's own state or sibling blobs.
+func chunkBlobName(hash string) string {
+	return fmt.Sprintf("chunks/%s.json", hash)
+}
+
+// GetChunk fetches the content-addressed chunk blob named hash, returning
+// ok=false rather than an error if it doesn't exist (e.g. a manifest
+// somehow references a chunk that was never uploaded).
+func (b *Blob) GetChunk(hash string) (data []byte, ok bool, returnErr error) {
+	blob := b.container.GetBlob(chunkBlobName(hash))
+	exists, err := blob.Exists()
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking chunk existence: %s", err)
+	}
+	if !exists {
+		return nil, false, nil
+	}
+
+	var body io.ReadCloser
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() (err error) {
+		body, err = blob.Get(&storage.GetBlobOptions{})
+		return err
+	}); err != nil {
+		return nil, false, fmt.Errorf("error getting chunk: %s", err)
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			returnErr = fmt.Errorf("error closing chunk: %s", err)
+		}
+	}()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, body); err != nil {
+		return nil, false, fmt.Errorf("error reading chunk: %s", err)
+	}
+	return buf.Bytes(), true, nil
+}
+
+// PutChunk writes data as the content-addressed chunk blob named hash, if
+// it isn't already there. Chunks are immutable: the same hash always means
+// the same content, so an existing chunk is left untouched rather than
+// re-uploaded.
+func (b *Blob) PutChunk(hash string, data []byte) error {
+	blob := b.container.GetBlob(chunkBlobName(hash))
+	exists, err := blob.Exists()
+	if err != nil {
+		return fmt.Errorf("error checking chunk existence: %s", err)
+	}
+	if exists {
+		return nil
+	}
+
+	blob.Properties.ContentType = "application/json"
+	blob.Properties.ContentLength = int64(len(data))
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blob.CreateBlockBlobFromReader(bytes.NewReader(data), &storage.PutBlobOptions{})
+	}); err != nil {
+		return fmt.Errorf("error creating chunk blob: %s", err)
+	}
+	return nil
 }
 
 // Delete deletes the blob.
@@ -171,37 +483,411 @@ func (b *Blob) Delete() (returnErr error) {
 
 	// Call the API to delete the blob!
 	del := true
-	if err := b.container.GetBlob(b.Name).Delete(&storage.DeleteBlobOptions{LeaseID: b.leaseID, DeleteSnapshots: &del}); err != nil {
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return b.container.GetBlob(b.Name).Delete(&storage.DeleteBlobOptions{LeaseID: b.leaseID, DeleteSnapshots: &del})
+	}); err != nil {
 		return fmt.Errorf("error deleting blob: %s", err)
 	}
+	// Best-effort, same reasoning as Put: the blob is already gone, so a
+	// caller retrying on this error would just fail again against a blob
+	// that no longer exists.
+	if err := b.appendAuditEntry("Delete", nil); err != nil {
+		log.Printf("[WARN] error recording audit entry: %s", err)
+	}
 	return nil
 }
 
-// Lock acquires the lease of the blob.
+// AuditEntry records one state-changing operation against the blob, as
+// appended to its audit log by appendAuditEntry and returned by
+// ReadAuditLog.
+type AuditEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Operation   string    `json:"operation"`              // "Put", "Delete", "Lock", or "Unlock".
+	LeaseID     string    `json:"lease_id"`
+	Principal   string    `json:"principal"`               // from SnapshotTags["principal"]; "" if untagged.
+	RunID       string    `json:"run_id"`                  // from SnapshotTags["run_id"]; "" if untagged.
+	StateSHA256 string    `json:"state_sha256,omitempty"` // SHA256 of the new state payload; empty for Delete/Lock/Unlock.
+}
+
+// auditBlobName returns the append blob a state blob named name's audit log
+// is kept under, namespaced the same way chunkBlobName namespaces resource
+// chunks.
+func auditBlobName(name string) string {
+	return fmt.Sprintf("audit/%s.log", name)
+}
+
+// appendAuditEntry appends one JSON line describing a state-changing
+// operation to the blob's append-blob audit log, creating the log (as an
+// empty append blob) the first time it's needed. Append blobs only ever
+// grow: each AppendBlock call commits a new block atomically, and nothing
+// already committed can be altered or removed by a later call, which is
+// what makes this an actual audit trail rather than just a log file that
+// happens to record the same information.
+func (b *Blob) appendAuditEntry(operation string, data []byte) error {
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Operation: operation,
+		LeaseID:   b.leaseID,
+		Principal: b.SnapshotTags["principal"],
+		RunID:     b.SnapshotTags["run_id"],
+	}
+	if data != nil {
+		sum := sha256.Sum256(data)
+		entry.StateSHA256 = hex.EncodeToString(sum[:])
+	}
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("error marshalling audit entry: %s", err)
+	}
+	line = append(line, '\n')
+
+	auditBlob := b.auditLogContainer().GetBlob(auditBlobName(b.Name))
+	exists, err := auditBlob.Exists()
+	if err != nil {
+		return fmt.Errorf("error checking audit log existence: %s", err)
+	}
+	if !exists {
+		if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+			return auditBlob.PutAppendBlob(&storage.PutBlobOptions{})
+		}); err != nil {
+			return fmt.Errorf("error creating audit log: %s", err)
+		}
+	}
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		_, err := auditBlob.AppendBlock(line, &storage.AppendBlockOptions{})
+		return err
+	}); err != nil {
+		return fmt.Errorf("error appending audit entry: %s", err)
+	}
+	return nil
+}
+
+// ReadAuditLog returns every audit entry recorded for the blob since since,
+// oldest first, so an operator can reconstruct who changed state and when.
+// An audit log that doesn't exist yet (no state-changing operation has
+// happened since audit logging was configured) returns no entries rather
+// than an error.
+func (b *Blob) ReadAuditLog(since time.Time) ([]AuditEntry, error) {
+	if err := b.isValid(); err != nil {
+		return nil, fmt.Errorf("blob is invalid: %s", err)
+	}
+
+	auditBlob := b.auditLogContainer().GetBlob(auditBlobName(b.Name))
+	exists, err := auditBlob.Exists()
+	if err != nil {
+		return nil, fmt.Errorf("error checking audit log existence: %s", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	var data io.ReadCloser
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() (err error) {
+		data, err = auditBlob.Get(&storage.GetBlobOptions{})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %s", err)
+	}
+	defer data.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding audit entry: %s", err)
+		}
+		if entry.Timestamp.Before(since) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning audit log: %s", err)
+	}
+	return entries, nil
+}
+
+// SnapshotInfo describes one snapshot of the state blob, as returned by
+// ListSnapshots.
+type SnapshotInfo struct {
+	SnapshotTime time.Time // the snapshot's timestamp, which also identifies it to Azure.
+	Size         int64     // the size, in bytes, of the state payload at the time of the snapshot.
+	Serial       uint64    // the secure state's serial as of the snapshot, decoded from its payload.
+	Lineage      string    // the secure state's lineage as of the snapshot, decoded from its payload.
+	Principal    string    // who/what caused the apply that produced this snapshot, from Blob.SnapshotTags; "" if untagged.
+	RunID        string    // the Terraform run that produced this snapshot, from Blob.SnapshotTags; "" if untagged.
+}
+
+// RetentionPolicy describes which of a blob's snapshots PruneSnapshots
+// should keep. It follows the standard tiered retention scheme (as used by
+// e.g. restic/borg): a snapshot is kept if it matches at least one rule.
+// A zero-valued field disables that rule.
+type RetentionPolicy struct {
+	KeepLast    int           // always keep the KeepLast most recent snapshots.
+	KeepWithin  time.Duration // keep every snapshot younger than this.
+	KeepDaily   int           // keep the most recent snapshot from each of the last KeepDaily distinct days that have one.
+	KeepWeekly  int           // same, bucketed by ISO week.
+	KeepMonthly int           // same, bucketed by calendar month.
+}
+
+// keep decides, for each of snapshots (assumed to belong to the same blob),
+// whether p's rules keep it, evaluated relative to now.
+func (p RetentionPolicy) keep(now time.Time, snapshots []SnapshotInfo) map[time.Time]bool {
+	sorted := make([]SnapshotInfo, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].SnapshotTime.After(sorted[j].SnapshotTime) })
+
+	kept := make(map[time.Time]bool)
+	for i, snap := range sorted {
+		if p.KeepLast > 0 && i < p.KeepLast {
+			kept[snap.SnapshotTime] = true
+		}
+		if p.KeepWithin > 0 && now.Sub(snap.SnapshotTime) <= p.KeepWithin {
+			kept[snap.SnapshotTime] = true
+		}
+	}
+
+	keepBucketed := func(n int, bucketOf func(time.Time) string) {
+		if n <= 0 {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, snap := range sorted {
+			if len(seen) >= n {
+				return
+			}
+			bucket := bucketOf(snap.SnapshotTime)
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			kept[snap.SnapshotTime] = true
+		}
+	}
+	keepBucketed(p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepBucketed(p.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepBucketed(p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	return kept
+}
+
+// ListSnapshots returns every snapshot of the blob, newest first, decoding
+// the serial and lineage each one's secure state payload carries so an
+// operator can tell which point in time to restore to without downloading
+// each one by hand.
+func (b *Blob) ListSnapshots() ([]SnapshotInfo, error) {
+	if err := b.isValid(); err != nil {
+		return nil, fmt.Errorf("blob is invalid: %s", err)
+	}
+
+	resp, err := b.container.BlobService.GetContainerReference(b.container.Name).ListBlobs(storage.ListBlobsParameters{
+		Prefix:  b.Name,
+		Include: &storage.IncludeBlobDataset{Snapshots: true, Metadata: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %s", err)
+	}
+
+	var snapshots []SnapshotInfo
+	for i := range resp.Blobs {
+		snap := resp.Blobs[i]
+		if snap.Name != b.Name || snap.Snapshot.IsZero() {
+			continue // the base blob itself, or a snapshot of a different blob sharing our prefix.
+		}
+
+		data, err := snap.Get(&storage.GetBlobOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error reading snapshot taken at %s: %s", snap.Snapshot, err)
+		}
+		var buf bytes.Buffer
+		_, copyErr := io.Copy(&buf, data)
+		data.Close()
+		if copyErr != nil {
+			return nil, fmt.Errorf("error reading snapshot taken at %s: %s", snap.Snapshot, copyErr)
+		}
+
+		var secureState common.SecureState
+		if err := json.Unmarshal(buf.Bytes(), &secureState); err != nil {
+			return nil, fmt.Errorf("error decoding snapshot taken at %s: %s", snap.Snapshot, err)
+		}
+
+		snapshots = append(snapshots, SnapshotInfo{
+			SnapshotTime: snap.Snapshot,
+			Size:         snap.Properties.ContentLength,
+			Serial:       secureState.Serial,
+			Lineage:      secureState.Lineage,
+			Principal:    snap.Metadata["principal"],
+			RunID:        snap.Metadata["run_id"],
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].SnapshotTime.After(snapshots[j].SnapshotTime) })
+	return snapshots, nil
+}
+
+// GetSnapshot fetches the raw body and metadata of the snapshot taken at
+// snapshotTime, for callers that need a past state's full resource data
+// (e.g. to resolve which secret store entries it still references) rather
+// than just the summary ListSnapshots returns.
+func (b *Blob) GetSnapshot(snapshotTime time.Time) ([]byte, map[string]string, error) {
+	if err := b.isValid(); err != nil {
+		return nil, nil, fmt.Errorf("blob is invalid: %s", err)
+	}
+
+	blobRef := b.container.GetBlob(b.Name)
+	blobRef.Snapshot = snapshotTime
+
+	var data io.ReadCloser
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() (err error) {
+		data, err = blobRef.Get(&storage.GetBlobOptions{})
+		return err
+	}); err != nil {
+		return nil, nil, fmt.Errorf("error getting snapshot taken at %s: %s", snapshotTime, err)
+	}
+	var buf bytes.Buffer
+	_, copyErr := io.Copy(&buf, data)
+	data.Close()
+	if copyErr != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot taken at %s: %s", snapshotTime, copyErr)
+	}
+
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blobRef.GetMetadata(&storage.GetBlobMetadataOptions{})
+	}); err != nil {
+		return nil, nil, fmt.Errorf("error getting metadata of snapshot taken at %s: %s", snapshotTime, err)
+	}
+	return buf.Bytes(), blobRef.Metadata, nil
+}
+
+// RestoreSnapshot copies the snapshot taken at snapshotTime over the
+// current base blob, under the lease the caller already holds, so an
+// operator can recover from a bad apply without hand-editing blobs in the
+// portal.
+func (b *Blob) RestoreSnapshot(snapshotTime time.Time) error {
+	if err := b.isValid(); err != nil {
+		return fmt.Errorf("blob is invalid: %s", err)
+	}
+	if err := b.isLeased(); err != nil {
+		return fmt.Errorf("no lease on blob: %s", err)
+	}
+
+	src := b.container.GetBlob(b.Name)
+	src.Snapshot = snapshotTime
+	dst := b.container.GetBlob(b.Name)
+	if err := dst.Copy(src.GetURL(), &storage.CopyOptions{LeaseID: b.leaseID}); err != nil {
+		return fmt.Errorf("error restoring snapshot taken at %s: %s", snapshotTime, err)
+	}
+	b.etag = dst.Properties.Etag
+	return nil
+}
+
+// PruneSnapshots deletes every snapshot of the blob that policy's retention
+// rules don't keep.
+func (b *Blob) PruneSnapshots(policy RetentionPolicy) error {
+	if err := b.isValid(); err != nil {
+		return fmt.Errorf("blob is invalid: %s", err)
+	}
+
+	snapshots, err := b.ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("error listing snapshots to prune: %s", err)
+	}
+	kept := policy.keep(time.Now(), snapshots)
+	for _, snap := range snapshots {
+		if kept[snap.SnapshotTime] {
+			continue
+		}
+		blobRef := b.container.GetBlob(b.Name)
+		blobRef.Snapshot = snap.SnapshotTime
+		if err := blobRef.Delete(&storage.DeleteBlobOptions{}); err != nil {
+			return fmt.Errorf("error deleting snapshot taken at %s: %s", snap.SnapshotTime, err)
+		}
+	}
+	return nil
+}
+
+// leaseDurationSeconds is how long an Azure blob lease lasts before it must
+// be renewed. 60 is the maximum duration Azure allows for a finite lease;
+// using a finite lease (rather than an infinite one) means a holder that
+// crashes without unlocking can eventually be force-unlocked instead of
+// locking the state out forever.
+const leaseDurationSeconds = 60
+
+// leaseConflictPolicy bounds how long Lock retries a 409 LeaseAlreadyPresent
+// before giving up: a few seconds, not minutes, so a lock genuinely held by
+// another operation is reported back quickly rather than stalling.
+var leaseConflictPolicy = retry.Policy{
+	BaseDelay:   500 * time.Millisecond,
+	MaxInterval: 2 * time.Second,
+	MaxElapsed:  10 * time.Second,
+}
+
+// Lock acquires the lease of the blob, then records lock info in its
+// metadata. The lease already serializes concurrent Lock calls against
+// each other, but the metadata write is additionally ETag-conditional: if
+// lockinfo metadata somehow already exists despite us holding a fresh
+// lease, we fail loudly instead of clobbering it.
 func (b *Blob) Lock(info *state.LockInfo) (string, error) {
 	// Check if blob is valid.
 	if err := b.isValid(); err != nil {
 		return "", fmt.Errorf("blob is invalid: %s", err)
 	}
 
-	// Acquire lease on blob.
-	leaseID, err := b.container.GetBlob(b.Name).AcquireLease(-1, info.ID, &storage.LeaseOptions{})
+	// Acquire lease on blob. A held lease surfaces as a 409 LeaseAlreadyPresent;
+	// retry it for a short, fixed budget (not the configurable retryPolicy) so a
+	// genuinely-held lock still fails fast instead of blocking the operation for
+	// however long retryPolicy allows.
+	blobRef := b.container.GetBlob(b.Name)
+	var leaseID string
+	err := retry.Do(leaseConflictPolicy, retry.LeaseConflict, func() (err error) {
+		leaseID, err = blobRef.AcquireLease(leaseDurationSeconds, info.ID, &storage.LeaseOptions{})
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("error acquiring lease: %s", err)
 	}
 	info.ID = leaseID
 	b.leaseID = info.ID
 
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blobRef.GetMetadata(&storage.GetBlobMetadataOptions{LeaseID: b.leaseID})
+	}); err != nil {
+		return "", fmt.Errorf("error getting metadata: %s", err)
+	}
+	if existing := blobRef.Metadata[lockinfo]; existing != "" {
+		return "", fmt.Errorf("blob already has lock info in its metadata even though we just acquired the lease; refusing to overwrite it")
+	}
+
 	// Write info about Terraform's lock into the blob's metadata.
 	if err := b.writeLockInfo(info); err != nil {
 		return "", fmt.Errorf("error writing lock info: %s", err)
 	}
 
+	// Best-effort, same reasoning as Put: the lease is already held.
+	if err := b.appendAuditEntry("Lock", nil); err != nil {
+		log.Printf("[WARN] error recording audit entry: %s", err)
+	}
+
 	// Return the path and ID to the blob.
 	info.Path = fmt.Sprintf("%s/%s", b.container.Name, b.Name)
 	return info.ID, nil
 }
 
+// RenewLease renews the lease currently held on the blob, extending its
+// expiry by another leaseDurationSeconds. Backend.Operation's
+// leaseRenewingLocker calls this periodically for the duration of a
+// long-running operation so the lease doesn't expire out from under it.
+func (b *Blob) RenewLease() error {
+	if err := b.isLeased(); err != nil {
+		return err
+	}
+	return b.container.GetBlob(b.Name).RenewLease(b.leaseID, &storage.LeaseOptions{})
+}
+
 // Unlock breaks the lease of the blob.
 func (b *Blob) Unlock(id string) error {
 	if err := b.isValid(); err != nil {
@@ -226,14 +912,61 @@ func (b *Blob) Unlock(id string) error {
 		return lockErr
 	}
 
-	if err = b.container.GetBlob(b.Name).ReleaseLease(id, &storage.LeaseOptions{}); err != nil {
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return b.container.GetBlob(b.Name).ReleaseLease(id, &storage.LeaseOptions{})
+	}); err != nil {
 		lockErr.Err = err
 		return lockErr
 	}
+	// Best-effort, same reasoning as Put: the lease is already released.
+	if err := b.appendAuditEntry("Unlock", nil); err != nil {
+		log.Printf("[WARN] error recording audit entry: %s", err)
+	}
 	b.leaseID = "" // set to "no lease acquired".
 	return nil
 }
 
+// ETag returns the ETag observed on the last successful Get or Put of this
+// blob, or "" if neither has happened yet. Callers use this to detect
+// whether the blob has changed since it was last read, e.g. to guard a
+// resumed apply against a state that drifted since the plan was made.
+func (b *Blob) ETag() string {
+	return b.etag
+}
+
+// Metadata returns the blob's current user metadata, such as the wrapped
+// DEK, IV, and key ID envelope encryption stores alongside the ciphertext
+// body (see PutWithMetadata). Unlike Get, which only returns the body,
+// this always round-trips to Azure, since Get itself doesn't fetch it.
+func (b *Blob) Metadata() (map[string]string, error) {
+	if err := b.isValid(); err != nil {
+		return nil, fmt.Errorf("blob is invalid: %s", err)
+	}
+	blob := b.container.GetBlob(b.Name)
+	if err := retry.Do(b.retryPolicy, retry.AzureTransient, func() error {
+		return blob.GetMetadata(&storage.GetBlobMetadataOptions{LeaseID: b.leaseID})
+	}); err != nil {
+		return nil, fmt.Errorf("error getting metadata: %s", err)
+	}
+	return blob.Metadata, nil
+}
+
+// Sibling returns the blob named name in the same container as b, creating
+// it (with an empty state payload) if it doesn't already exist. This is how
+// per-workspace artifacts, such as a remote plan file, are stored alongside
+// the state blob they relate to.
+func (b *Blob) Sibling(name string) (*Blob, error) {
+	return Setup(b.container, name, b.retryPolicy)
+}
+
+// PeekLockInfo reads the lock info currently recorded in the blob's
+// metadata without acquiring the lease, so callers can tell whether an
+// earlier operation left the blob locked (e.g. a process that crashed
+// mid-apply) before attempting to lock it themselves.
+func (b *Blob) PeekLockInfo() (*state.LockInfo, error) {
+	return b.readLockInfo()
+}
+
 // IsValid checks if the client's fields are set correctly before using it.
 func (b *Blob) isValid() error {
 	// Check if the container that contains the blob has been set.
@@ -4,11 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+	uuid "github.com/satori/go.uuid"
 
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/mgmt/2016-10-01/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	armStorage "github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-07-01/storage"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/azure-storage-blob-go/azblob"
@@ -18,128 +28,588 @@ import (
 type Container struct {
 	BlobService storage.BlobStorageClient // Client to communicate with the Azure Resource Manager to operate on Azure Blob Storage Accounts.
 	Name        string                    // The name of the container that contains the blob storing the remote state in JSON.
+	AccountName string                    // The name of the storage account the container lives in. Used by SetupAuditContainer to apply container-level policies.
+
+	// ServiceURL is the AAD token-based client for this storage account,
+	// set instead of a shared key credential when Setup was called with
+	// props.UseAzureADAuth. nil when authorized with a shared key.
+	ServiceURL *azblob.ServiceURL
 }
 
-// Setup creates a new remote client to the storage account.
+// Setup creates a new remote client to the storage account. When
+// props.StorageAccountName is set, Setup looks up that pre-existing
+// account (in props.StorageAccountResourceGroup, or props.ResourceGroupName
+// if that's empty) and uses it as-is instead of auto-provisioning one —
+// bring your own account for resource groups shared with other apps.
+// Otherwise it falls back to discovering/creating an account named after
+// props.ResourceGroupName, scoped to accounts whose name has that prefix
+// rather than every account in the resource group, so unrelated accounts
+// (logging, diagnostics, other apps) don't trip the "more than one" check
+// below.
 func Setup(ctx context.Context, props *properties.Properties, containerName string) (*Container, error) {
 	var c Container
 
 	accountsClient := armStorage.NewAccountsClient(props.SubscriptionID)
 	accountsClient.Authorizer = props.MgmtAuthorizer
 
-	// List to check for existing storage accounts.
-	result, err := accountsClient.ListByResourceGroup(ctx, props.Name)
-	if err != nil {
-		return nil, fmt.Errorf("error listing storage accounts by resource group %s: %s", props.Name, err)
-	}
-
 	var storageAccountName string
-	// Check if none exists. If none, create one.
-	if len(*result.Value) == 0 {
-		storageAccountName = props.Name
-
-		// Check if storage account name is available:
-		result, err := accountsClient.CheckNameAvailability(
-			ctx,
-			armStorage.AccountCheckNameAvailabilityParameters{
-				Name: to.StringPtr(storageAccountName),
-				Type: to.StringPtr("Microsoft.Storage/storageAccounts"),
-			})
+	if props.StorageAccountName != "" {
+		resourceGroup := props.StorageAccountResourceGroup
+		if resourceGroup == "" {
+			resourceGroup = props.ResourceGroupName
+		}
+		account, err := accountsClient.GetProperties(ctx, resourceGroup, props.StorageAccountName, "")
 		if err != nil {
-			return nil, fmt.Errorf("error checking available storage account names: %v", err)
-		}
-		if *result.NameAvailable != true {
-			return nil, fmt.Errorf("storage account name %s not available: %v", storageAccountName, err)
-		}
-
-		// Create a new storage account, since we have none.
-		// TODO: Setup soft delete.
-		httpsTrafficOnly := true
-		future, err := accountsClient.Create(
-			ctx,
-			props.Name,
-			storageAccountName,
-			armStorage.AccountCreateParameters{
-				Sku: &armStorage.Sku{
-					Name: armStorage.StandardLRS,
-				},
-				Kind:     armStorage.BlobStorage,
-				Location: to.StringPtr(props.Location),
-				AccountPropertiesCreateParameters: &armStorage.AccountPropertiesCreateParameters{
-					AccessTier:             armStorage.Hot,
-					EnableHTTPSTrafficOnly: &httpsTrafficOnly,
-				},
-			})
-
+			return nil, fmt.Errorf("error getting storage account %q in resource group %q: %s", props.StorageAccountName, resourceGroup, err)
+		}
+		storageAccountName = props.StorageAccountName
+		props.StorageAccountResourceID = *account.ID
+	} else {
+		// List to check for existing storage accounts, scoped to this
+		// backend's own naming prefix so other apps' accounts in the same
+		// resource group don't collide with the "exactly one" check below.
+		result, err := accountsClient.ListByResourceGroup(ctx, props.ResourceGroupName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to start creating storage account: %v", err)
+			return nil, fmt.Errorf("error listing storage accounts by resource group %s: %s", props.ResourceGroupName, err)
 		}
+		var matches []armStorage.Account
+		for _, account := range *result.Value {
+			if strings.HasPrefix(*account.Name, props.ResourceGroupName) {
+				matches = append(matches, account)
+			}
+		}
+
+		// Check if none exists. If none, create one.
+		if len(matches) == 0 {
+			storageAccountName = props.ResourceGroupName
+
+			// Check if storage account name is available:
+			result, err := accountsClient.CheckNameAvailability(
+				ctx,
+				armStorage.AccountCheckNameAvailabilityParameters{
+					Name: to.StringPtr(storageAccountName),
+					Type: to.StringPtr("Microsoft.Storage/storageAccounts"),
+				})
+			if err != nil {
+				return nil, fmt.Errorf("error checking available storage account names: %v", err)
+			}
+			if *result.NameAvailable != true {
+				return nil, fmt.Errorf("storage account name %s not available: %v", storageAccountName, err)
+			}
+
+			// Create a new storage account, since we have none. Soft delete, blob
+			// versioning, and the change feed are enabled afterwards, once the
+			// account exists, by setBlobServiceProperties below.
+			createParams, err := accountCreateParams(ctx, props)
+			if err != nil {
+				return nil, err
+			}
+			future, err := accountsClient.Create(
+				ctx,
+				props.ResourceGroupName,
+				storageAccountName,
+				createParams)
+			if err != nil {
+				return nil, fmt.Errorf("failed to start creating storage account: %v", err)
+			}
+
+			if future.WaitForCompletionRef(ctx, accountsClient.Client); err != nil {
+				return nil, fmt.Errorf("failed to finish creating storage account: %v", err)
+			}
 
-		if future.WaitForCompletionRef(ctx, accountsClient.Client); err != nil {
-			return nil, fmt.Errorf("failed to finish creating storage account: %v", err)
+			// Wait for creation completion.
+			storageAccount, err := future.Result(accountsClient)
+			if err != nil {
+				return nil, fmt.Errorf("error waiting for storage account creation: %v", err)
+			}
+			props.StorageAccountResourceID = *storageAccount.ID
+		} else if len(matches) != 1 {
+			return nil, fmt.Errorf("more than one storage account prefixed %q found in resource group %s; set storage_account_name to disambiguate", props.ResourceGroupName, props.ResourceGroupName)
+		} else {
+			storageAccountName = *matches[0].Name
+			props.StorageAccountResourceID = *matches[0].ID
 		}
+	}
 
-		// Wait for creation completion.
-		storageAccount, err := future.Result(accountsClient)
-		if err != nil {
-			return nil, fmt.Errorf("error waiting for storage account creation: %v", err)
+	if props.StorageCMKVaultURI != "" {
+		if err := configureCMK(ctx, props, accountsClient, storageAccountName); err != nil {
+			return nil, err
 		}
-		props.StorageAccountResourceID = *storageAccount.ID
-	} else if len(*result.Value) != 1 {
-		return nil, fmt.Errorf("only 1 storage account is allowed in the resource group %s", props.Name)
-	} else {
-		storageAccountName = *((*result.Value)[0]).Name
-		props.StorageAccountResourceID = *((*result.Value)[0]).ID
 	}
 
-	// Fetch an access key for storage account.
-	keys, err := accountsClient.ListKeys(ctx, props.Name, storageAccountName)
-	if err != nil {
-		return nil, fmt.Errorf("error listing the access keys in the storage account %q: %s", storageAccountName, err)
+	if props.PrivateEndpointSubnetID != "" {
+		if err := provisionPrivateEndpoint(ctx, props, storageAccountName); err != nil {
+			return nil, err
+		}
 	}
-	if keys.Keys == nil {
-		return nil, fmt.Errorf("no keys returned from storage account %q", storageAccountName)
+
+	if err := setBlobServiceProperties(ctx, props, storageAccountName); err != nil {
+		return nil, err
 	}
-	accessKey1 := *(*keys.Keys)[0].Value
-	if accessKey1 == "" {
-		return nil, errors.New("missing access key")
+
+	var pipelineCred azblob.Credential
+	var sasQuery string
+	if props.SASToken != "" {
+		// SAS auth: the token itself carries the authorization, so the
+		// pipeline needs no credential beyond appending it to every request
+		// URL below.
+		token := strings.TrimPrefix(props.SASToken, "?")
+		sasValues, err := url.ParseQuery(token)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing sas_token: %s", err)
+		}
+		storageClient, err := storage.NewAccountSASClient(storageAccountName, sasValues, azure.PublicCloud)
+		if err != nil {
+			return nil, fmt.Errorf("error creating SAS client for storage account %q: %s", storageAccountName, err)
+		}
+		pipelineCred = azblob.NewAnonymousCredential()
+		sasQuery = token
+		c.BlobService = storageClient.GetBlobService()
+	} else if props.UseAzureADAuth {
+		// Azure AD auth: skip ListKeys entirely and authorize with the
+		// token the caller resolved, refreshed on every expiry.
+		if props.StorageToken == nil {
+			return nil, errors.New("use_azuread_auth is set, but no storage token was resolved")
+		}
+		cred, err := storageTokenCredential(props.StorageToken)
+		if err != nil {
+			return nil, err
+		}
+		pipelineCred = cred
+		oauthClient := storage.NewOAuthTokenClient(storageAccountName, props.StorageToken)
+		c.BlobService = oauthClient.GetBlobService()
+	} else {
+		// Shared key auth: fetch an access key for the storage account.
+		keys, err := accountsClient.ListKeys(ctx, props.ResourceGroupName, storageAccountName)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the access keys in the storage account %q: %s", storageAccountName, err)
+		}
+		if keys.Keys == nil {
+			return nil, fmt.Errorf("no keys returned from storage account %q", storageAccountName)
+		}
+		accessKey1 := *(*keys.Keys)[0].Value
+		if accessKey1 == "" {
+			return nil, errors.New("missing access key")
+		}
+
+		storageClient, err := storage.NewBasicClient(storageAccountName, accessKey1)
+		if err != nil {
+			return nil, fmt.Errorf("error creating client for storage account %q: %s", storageAccountName, err)
+		}
+		skc, err := azblob.NewSharedKeyCredential(storageAccountName, accessKey1)
+		if err != nil {
+			return nil, fmt.Errorf("error creating shared key credential for storage account %q: %s", storageAccountName, err)
+		}
+		pipelineCred = skc
+		c.BlobService = storageClient.GetBlobService()
 	}
 
-	// Create new storage account client using fetched access key.
-	storageClient, err := storage.NewBasicClient(storageAccountName, accessKey1)
+	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", storageAccountName))
 	if err != nil {
-		return nil, fmt.Errorf("error creating client for storage account %q: %s", storageAccountName, err)
+		return nil, fmt.Errorf("error parsing storage account %q's blob endpoint: %s", storageAccountName, err)
+	}
+	if sasQuery != "" {
+		u.RawQuery = sasQuery
 	}
+	serviceURL := azblob.NewServiceURL(*u, azblob.NewPipeline(pipelineCred, azblob.PipelineOptions{}))
+	c.ServiceURL = &serviceURL
+	c.Name = containerName
+	c.AccountName = storageAccountName
 
 	// Check if the given container exists.
-	blobService := storageClient.GetBlobService()
-	c.Name = containerName
-	resp, err := blobService.ListContainers(storage.ListContainersParameters{Prefix: c.Name, MaxResults: 1})
+	resp, err := c.BlobService.ListContainers(storage.ListContainersParameters{Prefix: c.Name, MaxResults: 1})
 	if err != nil {
 		return nil, fmt.Errorf("error listing containers: %s", err)
 	}
 	for _, container := range resp.Containers {
-		// Did we find the container?
 		if container.Name == c.Name {
-			c.BlobService = blobService
 			return &c, nil // success!
 		}
 	}
 
 	// Create a new container in the storage account.
-	skc, _ := azblob.NewSharedKeyCredential(storageAccountName, accessKey1)
-	u, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", storageAccountName))
-	if _, err = azblob.NewServiceURL(*u, azblob.NewPipeline(skc, azblob.PipelineOptions{})).NewContainerURL(containerName).Create(
+	if _, err := serviceURL.NewContainerURL(containerName).Create(
 		ctx,
 		azblob.Metadata{},
 		azblob.PublicAccessNone,
 	); err != nil {
 		return nil, fmt.Errorf("error creating container %s: %s", containerName, err)
 	}
-	c.BlobService = blobService
 	return &c, nil
 }
 
+// SetupAuditContainer sets up (creating if necessary) the container that
+// holds workspaces' audit logs, then — when props.AuditImmutabilityDays or
+// props.AuditLegalHold is configured — locks it down so entries can't be
+// altered or deleted, even by the principal that wrote the state they
+// describe. Audit logs live in their own container, separate from the one
+// Setup returns for state, precisely so this lockdown doesn't also make the
+// state blob itself unwritable.
+func SetupAuditContainer(ctx context.Context, props *properties.Properties, containerName string) (*Container, error) {
+	c, err := Setup(ctx, props, containerName)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up audit container: %s", err)
+	}
+	if props.AuditImmutabilityDays == 0 && !props.AuditLegalHold {
+		return c, nil
+	}
+
+	containersClient := armStorage.NewBlobContainersClient(props.SubscriptionID)
+	containersClient.Authorizer = props.MgmtAuthorizer
+
+	if props.AuditImmutabilityDays > 0 {
+		if _, err := containersClient.CreateOrUpdateImmutabilityPolicy(ctx, props.ResourceGroupName, c.AccountName, containerName, "", armStorage.ImmutabilityPolicy{
+			ImmutabilityPolicyProperty: &armStorage.ImmutabilityPolicyProperty{
+				ImmutabilityPeriodSinceCreationInDays: to.Int32Ptr(props.AuditImmutabilityDays),
+				AllowProtectedAppendWrites:            to.BoolPtr(true),
+			},
+		}); err != nil {
+			return nil, fmt.Errorf("error setting immutability policy on audit container %q: %s", containerName, err)
+		}
+	}
+	if props.AuditLegalHold {
+		if _, err := containersClient.SetLegalHold(ctx, props.ResourceGroupName, c.AccountName, containerName, armStorage.LegalHold{
+			Tags: &[]string{"audit-log"},
+		}); err != nil {
+			return nil, fmt.Errorf("error setting legal hold on audit container %q: %s", containerName, err)
+		}
+	}
+	return c, nil
+}
+
+// storageTokenCredential adapts an ADAL service principal token into an
+// azblob.TokenCredential, refreshing it whenever the pipeline asks.
+func storageTokenCredential(spt *adal.ServicePrincipalToken) (azblob.TokenCredential, error) {
+	if err := spt.EnsureFresh(); err != nil {
+		return nil, fmt.Errorf("error refreshing storage token: %s", err)
+	}
+	return azblob.NewTokenCredential(spt.Token().AccessToken, func(tc azblob.TokenCredential) time.Duration {
+		if err := spt.EnsureFresh(); err != nil {
+			return 0 // retry almost immediately; the pipeline will keep calling this back.
+		}
+		tc.SetToken(spt.Token().AccessToken)
+		return time.Until(spt.Token().Expires()) - time.Minute
+	}), nil
+}
+
+// configureCMK switches storageAccountName's encryption from the
+// Microsoft-managed default to the customer-managed key named by
+// props.StorageCMK*. The three steps below must run in this order: a
+// storage account can't authenticate to the vault until it has an
+// identity, the vault can't be patched with a grant for an identity that
+// doesn't exist yet, and the encryption update itself is rejected by Azure
+// if the account doesn't already have get/wrapKey/unwrapKey on the key --
+// doing them out of order leaves the account half-configured and failing
+// reads/writes.
+func configureCMK(ctx context.Context, props *properties.Properties, accountsClient armStorage.AccountsClient, storageAccountName string) error {
+	// Step 1: give the account an identity to authenticate to the vault
+	// with. A user-assigned identity can be attached up front; a
+	// system-assigned one only gets a principal ID once the account has
+	// one, which is why this is an Update rather than being folded into
+	// the Create call above.
+	identity := &armStorage.Identity{Type: armStorage.IdentityTypeSystemAssigned}
+	if props.StorageCMKUserAssignedIdentityID != "" {
+		identity = &armStorage.Identity{
+			Type: armStorage.IdentityTypeUserAssigned,
+			UserAssignedIdentities: map[string]*armStorage.UserAssignedIdentity{
+				props.StorageCMKUserAssignedIdentityID: {},
+			},
+		}
+	}
+	future, err := accountsClient.Update(ctx, props.ResourceGroupName, storageAccountName, armStorage.AccountUpdateParameters{Identity: identity})
+	if err != nil {
+		return fmt.Errorf("error assigning an identity to storage account %q for CMK encryption: %s", storageAccountName, err)
+	}
+	account, err := future.Result(accountsClient)
+	if err != nil {
+		return fmt.Errorf("error waiting for storage account %q's identity to be assigned: %s", storageAccountName, err)
+	}
+
+	var principalID string
+	if props.StorageCMKUserAssignedIdentityID != "" {
+		ua, ok := account.Identity.UserAssignedIdentities[props.StorageCMKUserAssignedIdentityID]
+		if !ok || ua.PrincipalID == nil {
+			return fmt.Errorf("storage account %q has no principal ID for user-assigned identity %q", storageAccountName, props.StorageCMKUserAssignedIdentityID)
+		}
+		principalID = *ua.PrincipalID
+	} else {
+		if account.Identity == nil || account.Identity.PrincipalID == nil {
+			return fmt.Errorf("storage account %q has no system-assigned identity after requesting one", storageAccountName)
+		}
+		principalID = *account.Identity.PrincipalID
+	}
+
+	// Step 2: grant that identity get/wrapKey/unwrapKey on the key, so the
+	// encryption update below is authorized. The vault is assumed to live
+	// in the same resource group as the state resources, same as the rest
+	// of this package's key vault references.
+	vaultName, err := vaultNameFromURI(props.StorageCMKVaultURI)
+	if err != nil {
+		return fmt.Errorf("error determining vault name from %q: %s", props.StorageCMKVaultURI, err)
+	}
+	tenantID, err := uuid.FromString(props.TenantID)
+	if err != nil {
+		return fmt.Errorf("error parsing tenant ID %q: %s", props.TenantID, err)
+	}
+	vaultsClient := keyvault.NewVaultsClient(props.SubscriptionID)
+	vaultsClient.Authorizer = props.MgmtAuthorizer
+	if _, err := vaultsClient.UpdateAccessPolicy(ctx, props.ResourceGroupName, vaultName, keyvault.Add, keyvault.VaultAccessPolicyParameters{
+		Properties: &keyvault.VaultAccessPolicyProperties{
+			AccessPolicies: &[]keyvault.AccessPolicyEntry{
+				{
+					TenantID: &tenantID,
+					ObjectID: &principalID,
+					Permissions: &keyvault.Permissions{
+						Keys: &[]keyvault.KeyPermissions{keyvault.KeyPermissionsGet, keyvault.KeyPermissionsWrapKey, keyvault.KeyPermissionsUnwrapKey},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("error granting storage account %q access to key vault %q (the caller's MgmtAuthorizer needs permission to manage the vault's access policies): %s", storageAccountName, vaultName, err)
+	}
+
+	// Step 3: now that the grant has landed, point the account's
+	// encryption settings at the key.
+	future, err = accountsClient.Update(ctx, props.ResourceGroupName, storageAccountName, armStorage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &armStorage.AccountPropertiesUpdateParameters{
+			Encryption: &armStorage.Encryption{
+				Services: &armStorage.EncryptionServices{
+					Blob: &armStorage.EncryptionService{Enabled: to.BoolPtr(true)},
+				},
+				KeySource: armStorage.KeySourceMicrosoftKeyvault,
+				KeyVaultProperties: &armStorage.KeyVaultProperties{
+					KeyName:     to.StringPtr(props.StorageCMKKeyName),
+					KeyVersion:  to.StringPtr(props.StorageCMKKeyVersion),
+					KeyVaultURI: to.StringPtr(props.StorageCMKVaultURI),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error switching storage account %q to customer-managed key encryption: %s", storageAccountName, err)
+	}
+	if _, err := future.Result(accountsClient); err != nil {
+		return fmt.Errorf("error waiting for storage account %q's encryption settings to update: %s", storageAccountName, err)
+	}
+	return nil
+}
+
+// vaultNameFromURI extracts a vault's name from its URI
+// (https://myvault.vault.azure.net/ -> "myvault").
+func vaultNameFromURI(vaultURI string) (string, error) {
+	u, err := url.Parse(vaultURI)
+	if err != nil {
+		return "", err
+	}
+	name := strings.SplitN(u.Hostname(), ".", 2)[0]
+	if name == "" {
+		return "", fmt.Errorf("no hostname in vault URI %q", vaultURI)
+	}
+	return name, nil
+}
+
+// accountCreateParams builds the parameters for creating the storage
+// account. AccountTier/AccountReplication combine into the SKU unless
+// AccountSKU overrides it wholesale; AccountKind and AccessTier fall back to
+// "BlobStorage"/"Hot" when unset, and RequireTLS12 pins MinimumTLSVersion to
+// TLS 1.2. BlobStorage accounts reject ZRS/GZRS replication.
+func accountCreateParams(ctx context.Context, props *properties.Properties) (armStorage.AccountCreateParameters, error) {
+	sku := armStorage.SkuName(props.AccountTier + "_" + props.AccountReplication)
+	if sku == "_" {
+		sku = armStorage.StandardLRS
+	}
+	if props.AccountSKU != "" {
+		sku = armStorage.SkuName(props.AccountSKU)
+	}
+	kind := armStorage.Kind(props.AccountKind)
+	if kind == "" {
+		kind = armStorage.BlobStorage
+	}
+	accessTier := armStorage.AccessTier(props.AccessTier)
+	if accessTier == "" {
+		accessTier = armStorage.Hot
+	}
+	if kind == armStorage.BlobStorage && (props.AccountReplication == "ZRS" || props.AccountReplication == "GZRS") {
+		return armStorage.AccountCreateParameters{}, fmt.Errorf("account_kind %q does not support account_replication_type %q; use account_kind = \"StorageV2\" instead", props.AccountKind, props.AccountReplication)
+	}
+
+	httpsTrafficOnly := true
+	acctProps := &armStorage.AccountPropertiesCreateParameters{
+		AccessTier:             accessTier,
+		EnableHTTPSTrafficOnly: &httpsTrafficOnly,
+	}
+	if props.RequireTLS12 {
+		acctProps.MinimumTLSVersion = armStorage.TLS12
+	}
+	if len(props.NetworkAllowedSubnetIDs) > 0 || len(props.NetworkAllowedIPRanges) > 0 {
+		ruleSet, err := networkRuleSet(ctx, props)
+		if err != nil {
+			return armStorage.AccountCreateParameters{}, err
+		}
+		acctProps.NetworkRuleSet = ruleSet
+	}
+
+	return armStorage.AccountCreateParameters{
+		Sku: &armStorage.Sku{
+			Name: sku,
+		},
+		Kind:                              kind,
+		Location:                          to.StringPtr(props.Location),
+		AccountPropertiesCreateParameters: acctProps,
+	}, nil
+}
+
+// networkRuleSet builds the firewall to create a new storage account with:
+// default-deny, Azure services bypassed, the caller's allowed subnets and
+// IP ranges, plus the process's own egress IP (resolved or, if
+// props.ClientIP is set, taken as-is) so the List/Create container calls
+// later in Setup don't immediately 403 against the rule it just applied.
+func networkRuleSet(ctx context.Context, props *properties.Properties) (*armStorage.NetworkRuleSet, error) {
+	var vnetRules []armStorage.VirtualNetworkRule
+	for _, subnetID := range props.NetworkAllowedSubnetIDs {
+		vnetRules = append(vnetRules, armStorage.VirtualNetworkRule{
+			VirtualNetworkResourceID: to.StringPtr(subnetID),
+			Action:                   armStorage.Allow,
+		})
+	}
+
+	clientIP, err := resolveClientIP(ctx, props.ClientIP)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving client IP to allow-list on the storage account firewall: %s", err)
+	}
+	ipRanges := append(append([]string{}, props.NetworkAllowedIPRanges...), clientIP)
+	var ipRules []armStorage.IPRule
+	for _, ipRange := range ipRanges {
+		ipRules = append(ipRules, armStorage.IPRule{
+			IPAddressOrRange: to.StringPtr(ipRange),
+			Action:           armStorage.Allow,
+		})
+	}
+
+	return &armStorage.NetworkRuleSet{
+		DefaultAction:       armStorage.DefaultActionDeny,
+		Bypass:              armStorage.AzureServices,
+		VirtualNetworkRules: &vnetRules,
+		IPRules:             &ipRules,
+	}, nil
+}
+
+// resolveClientIP returns explicitIP as-is when set, otherwise detects the
+// process's own egress IP via an external "what's my IP" lookup.
+func resolveClientIP(ctx context.Context, explicitIP string) (string, error) {
+	if explicitIP != "" {
+		return explicitIP, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://api.ipify.org", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error detecting egress IP: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading detected egress IP: %s", err)
+	}
+	ip := strings.TrimSpace(string(body))
+	if ip == "" {
+		return "", errors.New("egress IP lookup returned an empty response; set client_ip explicitly")
+	}
+	return ip, nil
+}
+
+// provisionPrivateEndpoint creates a Private Endpoint for the storage
+// account's blob sub-resource into props.PrivateEndpointSubnetID, so
+// data-plane traffic resolves to a private IP instead of the public
+// endpoint, and links it to props.PrivateDNSZoneID via a DNS zone group
+// entry when set.
+func provisionPrivateEndpoint(ctx context.Context, props *properties.Properties, storageAccountName string) error {
+	peName := storageAccountName + "-pe"
+
+	peClient := network.NewPrivateEndpointsClient(props.SubscriptionID)
+	peClient.Authorizer = props.MgmtAuthorizer
+	future, err := peClient.CreateOrUpdate(ctx, props.ResourceGroupName, peName, network.PrivateEndpoint{
+		PrivateEndpointProperties: &network.PrivateEndpointProperties{
+			Subnet: &network.Subnet{ID: to.StringPtr(props.PrivateEndpointSubnetID)},
+			PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{
+				{
+					Name: to.StringPtr(peName),
+					PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+						PrivateLinkServiceID: to.StringPtr(props.StorageAccountResourceID),
+						GroupIds:             &[]string{"blob"},
+					},
+				},
+			},
+		},
+		Location: to.StringPtr(props.Location),
+	})
+	if err != nil {
+		return fmt.Errorf("error starting to create private endpoint %q: %s", peName, err)
+	}
+	if err := future.WaitForCompletionRef(ctx, peClient.Client); err != nil {
+		return fmt.Errorf("error creating private endpoint %q: %s", peName, err)
+	}
+
+	if props.PrivateDNSZoneID == "" {
+		return nil
+	}
+
+	zoneGroupClient := network.NewPrivateDNSZoneGroupsClient(props.SubscriptionID)
+	zoneGroupClient.Authorizer = props.MgmtAuthorizer
+	zgFuture, err := zoneGroupClient.CreateOrUpdate(ctx, props.ResourceGroupName, peName, peName+"-zonegroup", network.PrivateDNSZoneGroup{
+		PrivateDNSZoneGroupPropertiesFormat: &network.PrivateDNSZoneGroupPropertiesFormat{
+			PrivateDNSZoneConfigs: &[]network.PrivateDNSZoneConfig{
+				{
+					Name: to.StringPtr("blob"),
+					PrivateDNSZonePropertiesFormat: &network.PrivateDNSZonePropertiesFormat{
+						PrivateDNSZoneID: to.StringPtr(props.PrivateDNSZoneID),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error starting to link private DNS zone to private endpoint %q: %s", peName, err)
+	}
+	if err := zgFuture.WaitForCompletionRef(ctx, zoneGroupClient.Client); err != nil {
+		return fmt.Errorf("error linking private DNS zone to private endpoint %q: %s", peName, err)
+	}
+	return nil
+}
+
+// setBlobServiceProperties enables soft delete and blob versioning on the
+// storage account, and optionally the change feed, per props. It's
+// idempotent, so it's safe to call whether the account was just created or
+// already existed.
+func setBlobServiceProperties(ctx context.Context, props *properties.Properties, storageAccountName string) error {
+	retentionDays := props.BlobSoftDeleteRetentionDays
+	if retentionDays == 0 {
+		retentionDays = 90
+	}
+
+	blobServicesClient := armStorage.NewBlobServicesClient(props.SubscriptionID)
+	blobServicesClient.Authorizer = props.MgmtAuthorizer
+	if _, err := blobServicesClient.SetServiceProperties(ctx, props.ResourceGroupName, storageAccountName, armStorage.BlobServiceProperties{
+		BlobServicePropertiesProperties: &armStorage.BlobServicePropertiesProperties{
+			IsVersioningEnabled: to.BoolPtr(true),
+			DeleteRetentionPolicy: &armStorage.DeleteRetentionPolicy{
+				Enabled: to.BoolPtr(true),
+				Days:    to.Int32Ptr(retentionDays),
+			},
+			ChangeFeed: &armStorage.ChangeFeed{
+				Enabled: to.BoolPtr(props.EnableChangeFeed),
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("error enabling blob versioning and soft delete on storage account %q: %s", storageAccountName, err)
+	}
+	return nil
+}
+
 // List lists blobs in the container.
 func (c *Container) List() ([]storage.Blob, error) {
 	r, err := c.BlobService.GetContainerReference(c.Name).ListBlobs(storage.ListBlobsParameters{})
@@ -153,3 +623,45 @@ func (c *Container) List() ([]storage.Blob, error) {
 func (c *Container) GetBlob(blobName string) *storage.Blob {
 	return c.BlobService.GetContainerReference(c.Name).GetBlobReference(blobName)
 }
+
+// ListVersions returns the version IDs of blobName, newest first. A version
+// ID is the RFC3339 timestamp of one of the blob's prior versions, as kept
+// around by the blob versioning enabled in setBlobServiceProperties; pass
+// one to RestoreVersion to recover that content.
+func (c *Container) ListVersions(blobName string) ([]string, error) {
+	resp, err := c.BlobService.GetContainerReference(c.Name).ListBlobs(storage.ListBlobsParameters{
+		Prefix:  blobName,
+		Include: &storage.IncludeBlobDataset{Snapshots: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing versions of %s: %s", blobName, err)
+	}
+	var versions []string
+	for _, b := range resp.Blobs {
+		if b.Name != blobName || b.Snapshot.IsZero() {
+			continue
+		}
+		versions = append(versions, b.Snapshot.Format(time.RFC3339))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions, nil
+}
+
+// RestoreVersion restores blobName to the version identified by versionID
+// (one of the IDs returned by ListVersions), via a server-side copy. This
+// gives operators a recovery path for corrupted or accidentally-overwritten
+// state beyond whatever local .backup file the CLI happened to produce.
+func (c *Container) RestoreVersion(blobName, versionID string) error {
+	snapshotTime, err := time.Parse(time.RFC3339, versionID)
+	if err != nil {
+		return fmt.Errorf("invalid version ID %q: %s", versionID, err)
+	}
+
+	src := c.GetBlob(blobName)
+	src.Snapshot = snapshotTime
+	dst := c.GetBlob(blobName)
+	if err := dst.Copy(src.GetURL(), &storage.CopyOptions{}); err != nil {
+		return fmt.Errorf("error restoring %s to version %s: %s", blobName, versionID, err)
+	}
+	return nil
+}
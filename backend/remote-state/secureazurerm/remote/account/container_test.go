@@ -0,0 +1,60 @@
+package account
+
+import (
+	"context"
+	"testing"
+
+	armStorage "github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-07-01/storage"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+)
+
+func TestAccountCreateParamsDefaults(t *testing.T) {
+	params, err := accountCreateParams(context.Background(), &properties.Properties{})
+	if err != nil {
+		t.Fatalf("accountCreateParams: %s", err)
+	}
+	if params.Sku == nil || params.Sku.Name != armStorage.StandardLRS {
+		t.Errorf("got SKU %v, want %s", params.Sku, armStorage.StandardLRS)
+	}
+	if params.Kind != armStorage.BlobStorage {
+		t.Errorf("got kind %v, want %s", params.Kind, armStorage.BlobStorage)
+	}
+	if params.AccessTier != armStorage.Hot {
+		t.Errorf("got access tier %v, want %s", params.AccessTier, armStorage.Hot)
+	}
+	if params.MinimumTLSVersion != "" {
+		t.Errorf("MinimumTLSVersion should be unset by default, got %v", params.MinimumTLSVersion)
+	}
+}
+
+// TestAccountCreateParamsBootstrap asserts that a bootstrap {} block's
+// non-default values (AccountSKU and RequireTLS12, as wired by
+// Backend.configure) actually change the AccountCreateParameters
+// account.Setup creates the storage account with.
+func TestAccountCreateParamsBootstrap(t *testing.T) {
+	params, err := accountCreateParams(context.Background(), &properties.Properties{
+		AccountTier:        "Standard",
+		AccountReplication: "LRS",
+		AccountSKU:         "Premium_ZRS",
+		RequireTLS12:       true,
+	})
+	if err != nil {
+		t.Fatalf("accountCreateParams: %s", err)
+	}
+	if params.Sku == nil || params.Sku.Name != armStorage.SkuName("Premium_ZRS") {
+		t.Errorf("AccountSKU override did not take effect: got %v, want Premium_ZRS", params.Sku)
+	}
+	if params.MinimumTLSVersion != armStorage.TLS12 {
+		t.Errorf("RequireTLS12 did not set MinimumTLSVersion: got %v, want %s", params.MinimumTLSVersion, armStorage.TLS12)
+	}
+}
+
+func TestAccountCreateParamsRejectsBlobStorageWithZRS(t *testing.T) {
+	_, err := accountCreateParams(context.Background(), &properties.Properties{
+		AccountKind:        "BlobStorage",
+		AccountReplication: "ZRS",
+	})
+	if err == nil {
+		t.Fatal("expected an error for account_kind=BlobStorage with account_replication_type=ZRS, got nil")
+	}
+}
@@ -1,81 +1,317 @@
+// Package auth builds Azure authorizers for the backend and its Key Vault
+// client from whichever credential source is available, so the backend can
+// run unattended (CI runners, AKS pods with workload identity) as well as
+// interactively via the Azure CLI.
 package auth
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"os/exec"
-
-	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+	"strings"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
-// NewMgmt creates a new authorizer using resource management endpoint.
-func NewMgmt() (props properties.Properties, err error) {
-	// Try authorizing using Azure CLI, which will use the resource: https://management.azure.com/.
-	props.MgmtAuthorizer, err = auth.NewAuthorizerFromCLIWithResource(azure.PublicCloud.ResourceManagerEndpoint)
+const vaultResource = "https://vault.azure.net"     // don't use azure.PublicCloud.KeyVaultEndpoint: it's formatted incorrectly (trailing slash).
+const storageResource = "https://storage.azure.com" // resource ID for Azure AD-based blob data-plane access.
+
+// Config describes the credential sources that New will try, in priority
+// order, until one succeeds.
+type Config struct {
+	// Explicit service principal (client secret).
+	ClientID     string
+	ClientSecret string
+	TenantID     string
+
+	// Service principal with certificate.
+	ClientCertificatePath     string
+	ClientCertificatePassword string
+
+	// Federated OIDC token (e.g. GitHub Actions OIDC, Kubernetes workload identity).
+	OIDCToken         string // the token value itself, if already available.
+	OIDCTokenFilePath string // path to read the token from, re-read on every authorizer refresh.
+
+	// IMDS-based managed identity.
+	UseMSI                 bool
+	MSIEndpoint            string // optional; defaults to the standard IMDS endpoint.
+	UserAssignedIdentityID string // optional; client ID of a user-assigned identity. Unset selects the system-assigned identity.
+
+	SubscriptionID string
+}
+
+// Credentials bundles the authorizers and identity info resolved by New.
+type Credentials struct {
+	MgmtAuthorizer  autorest.Authorizer
+	VaultAuthorizer autorest.Authorizer
+
+	// StorageToken authorizes blob data-plane requests directly against
+	// https://storage.azure.com, for callers that want to use Azure AD
+	// rather than a storage account access key (see use_azuread_auth). It's
+	// nil when Credentials was resolved via the Azure CLI fallback, which
+	// has no way to mint a token for an arbitrary resource outside of an
+	// autorest.Authorizer.
+	StorageToken *adal.ServicePrincipalToken
+
+	SubscriptionID string
+	TenantID       string
+	ObjectID       string
+}
+
+// New resolves credentials by trying, in order: an explicit service
+// principal (client secret), a service principal with a certificate, a
+// federated OIDC token, IMDS-based managed identity, and finally the Azure
+// CLI as a last resort for interactive use.
+func New(cfg Config) (*Credentials, error) {
+	switch {
+	case cfg.ClientID != "" && cfg.ClientSecret != "":
+		return newFromClientSecret(cfg)
+	case cfg.ClientID != "" && cfg.ClientCertificatePath != "":
+		return newFromClientCertificate(cfg)
+	case cfg.OIDCToken != "" || cfg.OIDCTokenFilePath != "":
+		return newFromOIDCToken(cfg)
+	case cfg.UseMSI:
+		return newFromMSI(cfg)
+	default:
+		return newFromCLI(cfg)
+	}
+}
+
+func newFromClientSecret(cfg Config) (*Credentials, error) {
+	mgmtAuthorizer, err := auth.NewClientCredentialsConfig(cfg.ClientID, cfg.ClientSecret, cfg.TenantID).Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating authorizer from client secret: %s", err)
+	}
+	vaultConfig := auth.NewClientCredentialsConfig(cfg.ClientID, cfg.ClientSecret, cfg.TenantID)
+	vaultConfig.Resource = vaultResource
+	vaultAuthorizer, err := vaultConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault authorizer from client secret: %s", err)
+	}
+	storageConfig := auth.NewClientCredentialsConfig(cfg.ClientID, cfg.ClientSecret, cfg.TenantID)
+	storageConfig.Resource = storageResource
+	storageToken, err := storageConfig.ServicePrincipalToken()
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage token from client secret: %s", err)
+	}
+	objectID, err := objectIDFromToken(storageToken)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving caller's object ID: %s", err)
+	}
+	return &Credentials{
+		MgmtAuthorizer:  mgmtAuthorizer,
+		VaultAuthorizer: vaultAuthorizer,
+		StorageToken:    storageToken,
+		SubscriptionID:  cfg.SubscriptionID,
+		TenantID:        cfg.TenantID,
+		ObjectID:        objectID,
+	}, nil
+}
+
+func newFromClientCertificate(cfg Config) (*Credentials, error) {
+	certConfig := auth.NewClientCertificateConfig(cfg.ClientCertificatePath, cfg.ClientCertificatePassword, cfg.ClientID, cfg.TenantID)
+	mgmtAuthorizer, err := certConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating authorizer from client certificate: %s", err)
+	}
+	vaultCertConfig := auth.NewClientCertificateConfig(cfg.ClientCertificatePath, cfg.ClientCertificatePassword, cfg.ClientID, cfg.TenantID)
+	vaultCertConfig.Resource = vaultResource
+	vaultAuthorizer, err := vaultCertConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault authorizer from client certificate: %s", err)
+	}
+	storageCertConfig := auth.NewClientCertificateConfig(cfg.ClientCertificatePath, cfg.ClientCertificatePassword, cfg.ClientID, cfg.TenantID)
+	storageCertConfig.Resource = storageResource
+	storageToken, err := storageCertConfig.ServicePrincipalToken()
 	if err != nil {
-		err = fmt.Errorf("error creating new authorizer from CLI with resource %s: %v", azure.PublicCloud.ResourceManagerEndpoint, err)
-		return
+		return nil, fmt.Errorf("error creating storage token from client certificate: %s", err)
 	}
-	// Fetch subscriptionID and tenantID from Azure CLI.
-	var out []byte
-	out, err = exec.Command("az", "account", "show", "--output", "json").Output()
+	objectID, err := objectIDFromToken(storageToken)
 	if err != nil {
-		err = fmt.Errorf("error fetching subscription id using Azure CLI: %s", err)
-		return
+		return nil, fmt.Errorf("error resolving caller's object ID: %s", err)
+	}
+	return &Credentials{
+		MgmtAuthorizer:  mgmtAuthorizer,
+		VaultAuthorizer: vaultAuthorizer,
+		StorageToken:    storageToken,
+		SubscriptionID:  cfg.SubscriptionID,
+		TenantID:        cfg.TenantID,
+		ObjectID:        objectID,
+	}, nil
+}
+
+// newFromOIDCToken exchanges a federated OIDC token (e.g. from a Kubernetes
+// service account projected token, or a CI system's OIDC token) for an
+// Azure AD access token via client assertion.
+func newFromOIDCToken(cfg Config) (*Credentials, error) {
+	token := cfg.OIDCToken
+	if token == "" {
+		b, err := ioutil.ReadFile(cfg.OIDCTokenFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading OIDC token file %s: %s", cfg.OIDCTokenFilePath, err)
+		}
+		token = string(b)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, cfg.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OAuth config: %s", err)
+	}
+
+	mgmtSpt, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthConfig, cfg.ClientID, token, azure.PublicCloud.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error creating management token from federated OIDC token: %s", err)
+	}
+	vaultSpt, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthConfig, cfg.ClientID, token, vaultResource)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault token from federated OIDC token: %s", err)
+	}
+	storageSpt, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthConfig, cfg.ClientID, token, storageResource)
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage token from federated OIDC token: %s", err)
+	}
+
+	objectID, err := objectIDFromToken(storageSpt)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving caller's object ID: %s", err)
+	}
+	return &Credentials{
+		MgmtAuthorizer:  autorest.NewBearerAuthorizer(mgmtSpt),
+		VaultAuthorizer: autorest.NewBearerAuthorizer(vaultSpt),
+		StorageToken:    storageSpt,
+		SubscriptionID:  cfg.SubscriptionID,
+		TenantID:        cfg.TenantID,
+		ObjectID:        objectID,
+	}, nil
+}
+
+// newFromMSI authorizes via IMDS-based managed identity - the primary
+// deployment target for this backend (AKS pods with workload identity, or
+// any Azure compute resource with a system/user-assigned identity attached).
+func newFromMSI(cfg Config) (*Credentials, error) {
+	msiConfig := auth.NewMSIConfig()
+	msiConfig.Resource = azure.PublicCloud.ResourceManagerEndpoint
+	if cfg.MSIEndpoint != "" {
+		msiConfig.MSIEndpoint = cfg.MSIEndpoint
+	}
+	if cfg.UserAssignedIdentityID != "" {
+		msiConfig.ClientID = cfg.UserAssignedIdentityID
+	}
+	mgmtAuthorizer, err := msiConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating authorizer from managed identity: %s", err)
+	}
+
+	vaultMSIConfig := msiConfig
+	vaultMSIConfig.Resource = vaultResource
+	vaultAuthorizer, err := vaultMSIConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault authorizer from managed identity: %s", err)
+	}
+
+	storageMSIConfig := msiConfig
+	storageMSIConfig.Resource = storageResource
+	storageToken, err := storageMSIConfig.ServicePrincipalToken()
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage token from managed identity: %s", err)
+	}
+
+	objectID, err := objectIDFromToken(storageToken)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving object ID for managed identity: %s", err)
+	}
+
+	return &Credentials{
+		MgmtAuthorizer:  mgmtAuthorizer,
+		VaultAuthorizer: vaultAuthorizer,
+		StorageToken:    storageToken,
+		SubscriptionID:  cfg.SubscriptionID,
+		TenantID:        cfg.TenantID,
+		ObjectID:        objectID,
+	}, nil
+}
+
+// newFromCLI is the final fallback, used for interactive/local development.
+func newFromCLI(cfg Config) (*Credentials, error) {
+	mgmtAuthorizer, err := auth.NewAuthorizerFromCLIWithResource(azure.PublicCloud.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error creating new authorizer from CLI with resource %s: %v", azure.PublicCloud.ResourceManagerEndpoint, err)
+	}
+	vaultAuthorizer, err := auth.NewAuthorizerFromCLIWithResource(vaultResource)
+	if err != nil {
+		return nil, fmt.Errorf("error creating new authorizer from CLI with resource %s: %v", vaultResource, err)
+	}
+
+	out, err := exec.Command("az", "account", "show", "--output", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching subscription id using Azure CLI: %s", err)
 	}
 	var m map[string]interface{}
-	if err = json.Unmarshal(out, &m); err != nil {
-		err = fmt.Errorf("error unmarshalling subscription ID and tenant ID from JSON output from Azure CLI: %s", err)
-		return
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, fmt.Errorf("error unmarshalling subscription ID and tenant ID from JSON output from Azure CLI: %s", err)
+	}
+	creds := &Credentials{
+		MgmtAuthorizer:  mgmtAuthorizer,
+		VaultAuthorizer: vaultAuthorizer,
+		SubscriptionID:  m["id"].(string),
+		TenantID:        m["tenantId"].(string),
 	}
-	props.SubscriptionID = m["id"].(string)
-	props.TenantID = m["tenantId"].(string)
 	user := m["user"].(map[string]interface{})
 
-	// Get the objectID of the signed-in user.
-	userType := user["type"].(string)
-	switch userType {
+	switch userType := user["type"].(string); userType {
 	case "servicePrincipal":
 		clientID := user["name"].(string)
 		out, err = exec.Command("az", "ad", "sp", "show", "--id", clientID, "--output", "json", "--query", "objectId").Output()
 		if err != nil {
-			err = fmt.Errorf("error getting service principal: %s", err)
-			return
+			return nil, fmt.Errorf("error getting service principal: %s", err)
 		}
-		os.Setenv("ARM_CLIENT_ID", clientID)
-		os.Setenv("ARM_CLIENT_SECRET", os.Getenv("servicePrincipalKey")) // defined in the agent after enabling a setting.
-		os.Setenv("ARM_SUBSCRIPTION_ID", props.SubscriptionID)
-		os.Setenv("ARM_TENANT_ID", props.TenantID)
 	case "user":
 		out, err = exec.Command("az", "ad", "signed-in-user", "show", "--output", "json", "--query", "objectId").Output()
 		if err != nil {
-			err = fmt.Errorf("error getting signed-in user: %s", err)
-			return
+			return nil, fmt.Errorf("error getting signed-in user: %s", err)
 		}
 	default:
-		err = fmt.Errorf("unknown user type")
-		return
+		return nil, fmt.Errorf("unknown user type %q", userType)
 	}
-	if err = json.Unmarshal(out, &props.ObjectID); err != nil {
-		err = fmt.Errorf("error unmarshalling object ID from JSON output from Azure CLI: %s", err)
-		return
+	if err := json.Unmarshal(out, &creds.ObjectID); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object ID from JSON output from Azure CLI: %s", err)
 	}
-	err = nil
-	return
+	return creds, nil
 }
 
-// NewVault creates a new authorizer using keyvault endpoint (don't use the constant, because it is formatted incorrectly).
-func NewVault() (authorizer autorest.Authorizer, err error) {
-	vaultEndpoint := "https://vault.azure.net"
-	authorizer, err = auth.NewAuthorizerFromCLIWithResource(vaultEndpoint)
+// objectIDFromToken resolves the calling principal's object ID by decoding
+// the "oid" claim out of an already-acquired AAD access token, rather than
+// looking the identity up by client/application ID: client_id identifies the
+// application, not the service-principal (or managed-identity) object that
+// access policies and role assignments actually grant to, and the two are
+// different AAD objects. Every AAD v1/v2 token carries "oid" regardless of
+// which resource it was issued for, so spt can be any of the tokens New
+// already acquires (mgmt, vault, or storage) — no extra round trip needed.
+func objectIDFromToken(spt *adal.ServicePrincipalToken) (string, error) {
+	if err := spt.EnsureFresh(); err != nil {
+		return "", fmt.Errorf("error refreshing token: %s", err)
+	}
+	parts := strings.Split(spt.Token().AccessToken, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("access token is not a JWT: expected 3 dot-separated parts, got %d", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		err = fmt.Errorf("error creating new authorizer from CLI with resource %s: %v", vaultEndpoint, err)
-		return
+		return "", fmt.Errorf("error base64-decoding token claims: %s", err)
+	}
+	var claims struct {
+		ObjectID string `json:"oid"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("error unmarshalling token claims: %s", err)
+	}
+	if claims.ObjectID == "" {
+		return "", fmt.Errorf("access token has no oid claim")
 	}
-	return
+	return claims.ObjectID, nil
 }
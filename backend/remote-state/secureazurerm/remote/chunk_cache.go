@@ -0,0 +1,59 @@
+package remote
+
+import (
+	"container/list"
+	"sync"
+)
+
+// chunkCacheCapacity bounds how many resource chunks a State's chunkCache
+// keeps in memory at once. Plenty for the steady-state resource count of
+// any one workspace, while still bounding memory for a workspace with
+// thousands of resources.
+const chunkCacheCapacity = 256
+
+// chunkCache is a fixed-capacity, least-recently-used cache of
+// content-addressed chunk blob bodies keyed by hash, so repeated
+// RefreshState calls against a mostly-unchanged manifest don't re-fetch
+// the chunk blob of every resource that didn't change.
+type chunkCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type chunkCacheEntry struct {
+	hash string
+	data []byte
+}
+
+func newChunkCache() *chunkCache {
+	return &chunkCache{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *chunkCache) get(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[hash]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*chunkCacheEntry).data, true
+}
+
+func (c *chunkCache) put(hash string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[hash] = c.ll.PushFront(&chunkCacheEntry{hash: hash, data: data})
+	if c.ll.Len() > chunkCacheCapacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*chunkCacheEntry).hash)
+	}
+}
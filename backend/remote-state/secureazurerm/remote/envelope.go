@@ -0,0 +1,210 @@
+package remote
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// dekSize is the size, in bytes, of the AES-256 data encryption key (DEK)
+// generated fresh for every write.
+const dekSize = 32
+
+// Blob metadata keys envelope encryption stores its wrapping material
+// under, alongside the AES-GCM ciphertext body. Keeping them out of the
+// body means the body is the ciphertext directly, not a JSON wrapper
+// around it, so e.g. ListSnapshots' plain-SecureState decoding path and
+// the encrypted path never have to share a framing format.
+const (
+	metaKid        = "envelopekid"
+	metaAlg        = "envelopealg"
+	metaIV         = "envelopeiv"
+	metaWrappedDEK = "envelopewrappeddek"
+	metaKeySHA256  = "envelopekeysha256"
+)
+
+// encryptPayload AES-GCM-encrypts plaintext under a DEK and returns the
+// ciphertext body plus the blob metadata PersistState should write
+// alongside it so decryptPayload can recover the DEK later. If
+// Props.EncryptionKeyBase64 is set, that key is used directly as the DEK
+// (customer-provided-key mode, never touching Key Vault); otherwise a fresh
+// DEK is generated per call and wrapped with
+// Props.EncryptionKeyName/EncryptionKeyVersion.
+func (s *State) encryptPayload(plaintext []byte) (ciphertext []byte, metadata map[string]string, err error) {
+	if s.Props.EncryptionKeyBase64 != "" {
+		return s.encryptPayloadWithCustomerKey(plaintext)
+	}
+
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, fmt.Errorf("error generating data encryption key: %s", err)
+	}
+
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("error generating nonce: %s", err)
+	}
+	ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+
+	kid, wrappedDEK, err := s.KeyVault.WrapKey(context.Background(), s.Props.EncryptionKeyName, s.Props.EncryptionKeyVersion, dek)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error wrapping data encryption key: %s", err)
+	}
+
+	return ciphertext, map[string]string{
+		metaKid:        kid,
+		metaAlg:        "RSA-OAEP-256",
+		metaIV:         base64.StdEncoding.EncodeToString(nonce),
+		metaWrappedDEK: base64.StdEncoding.EncodeToString(wrappedDEK),
+	}, nil
+}
+
+// encryptPayloadWithCustomerKey is encryptPayload's customer-provided-key
+// path: Props.EncryptionKeyBase64 is used as the DEK as-is, and a SHA256 of
+// the key (not the key itself) is stored in metadata so decryptPayload can
+// confirm it's looking at the same key without ever persisting it.
+func (s *State) encryptPayloadWithCustomerKey(plaintext []byte) (ciphertext []byte, metadata map[string]string, err error) {
+	key, err := base64.StdEncoding.DecodeString(s.Props.EncryptionKeyBase64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding state_encryption_key: %s", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("error generating nonce: %s", err)
+	}
+	ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+
+	sum := sha256.Sum256(key)
+	return ciphertext, map[string]string{
+		metaAlg:       "AES256-CPK",
+		metaIV:        base64.StdEncoding.EncodeToString(nonce),
+		metaKeySHA256: base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// decryptPayload reverses encryptPayload, given the blob metadata Get
+// returned alongside ciphertext. It fails if metadata doesn't carry a
+// wrapped DEK or a customer key's SHA256, which callers rely on to fall
+// back to treating ciphertext as a plain (unencrypted) SecureState, so
+// blobs written before encryption was enabled keep reading correctly.
+func (s *State) decryptPayload(ciphertext []byte, metadata map[string]string) ([]byte, error) {
+	if metadata[metaKeySHA256] != "" {
+		return s.decryptPayloadWithCustomerKey(ciphertext, metadata)
+	}
+
+	wrappedDEKBase64 := metadata[metaWrappedDEK]
+	if wrappedDEKBase64 == "" {
+		return nil, fmt.Errorf("not an envelope-encrypted payload")
+	}
+	kid := metadata[metaKid]
+
+	wrappedDEK, err := base64.StdEncoding.DecodeString(wrappedDEKBase64)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding wrapped data encryption key: %s", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(metadata[metaIV])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nonce: %s", err)
+	}
+
+	dek, err := s.unwrapDEKCached(context.Background(), kid, wrappedDEKBase64, wrappedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data encryption key: %s", err)
+	}
+
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting state: %s", err)
+	}
+	return plaintext, nil
+}
+
+// decryptPayloadWithCustomerKey is decryptPayload's customer-provided-key
+// path: it checks metadata's stored SHA256 against Props.EncryptionKeyBase64
+// before decrypting, so a state_encryption_key misconfigured to the wrong
+// key fails with a clear error instead of an opaque AES-GCM auth failure.
+func (s *State) decryptPayloadWithCustomerKey(ciphertext []byte, metadata map[string]string) ([]byte, error) {
+	if s.Props.EncryptionKeyBase64 == "" {
+		return nil, fmt.Errorf("state was encrypted with a customer-provided key, but state_encryption_key is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(s.Props.EncryptionKeyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state_encryption_key: %s", err)
+	}
+	sum := sha256.Sum256(key)
+	if base64.StdEncoding.EncodeToString(sum[:]) != metadata[metaKeySHA256] {
+		return nil, fmt.Errorf("state_encryption_key does not match the key this state was encrypted with")
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(metadata[metaIV])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nonce: %s", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting state: %s", err)
+	}
+	return plaintext, nil
+}
+
+// unwrapDEKCached calls KeyVault.UnwrapKey, memoizing the result by (kid,
+// wrapped DEK) so that repeated RefreshState calls against an unchanged
+// blob don't round-trip to Key Vault every time.
+func (s *State) unwrapDEKCached(ctx context.Context, kid, wrappedDEKBase64 string, wrappedDEK []byte) ([]byte, error) {
+	cacheKey := kid + "#" + wrappedDEKBase64
+
+	s.dekCacheMu.Lock()
+	if dek, ok := s.dekCache[cacheKey]; ok {
+		s.dekCacheMu.Unlock()
+		return dek, nil
+	}
+	s.dekCacheMu.Unlock()
+
+	dek, err := s.KeyVault.UnwrapKey(ctx, kid, wrappedDEK)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dekCacheMu.Lock()
+	if s.dekCache == nil {
+		s.dekCache = make(map[string][]byte)
+	}
+	s.dekCache[cacheKey] = dek
+	s.dekCacheMu.Unlock()
+
+	return dek, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES-GCM: %s", err)
+	}
+	return gcm, nil
+}
@@ -3,26 +3,49 @@ package keyvault
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"
 	KV "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/mgmt/2016-10-01/keyvault"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/auth"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/retry"
 	uuid "github.com/satori/go.uuid"
 
-	azauth "github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
+// AuthorizationMode selects how the key vault authorizes data-plane access:
+// either classic vault access policies, or Azure RBAC role assignments
+// scoped to the vault. Some organizations' Azure Policy assignments forbid
+// the legacy access-policy model, hence RBAC.
+type AuthorizationMode string
+
+const (
+	// AccessPolicy grants access via the vault's classic access policy list.
+	AccessPolicy AuthorizationMode = "AccessPolicy"
+	// RBAC grants access via Azure RBAC role assignments scoped to the vault.
+	RBAC AuthorizationMode = "RBAC"
+)
+
 // KeyVault represents an Azure Key Vault.
 type KeyVault struct {
 	vaultName   string
 	vaultURI    string
+	vaultID     string
 	vaultClient keyvault.VaultsClient
 	keyClient   KV.BaseClient
+	roleClient  authorization.RoleAssignmentsClient
 
 	resourceGroupName string
 	workspace         string
 	location          string
+	authMode          AuthorizationMode
+
+	retryPolicy retry.Policy // governs how secrets.go's data-plane calls retry transient Azure errors.
 }
 
 // Name returns the name of the key vault.
@@ -30,55 +53,67 @@ func (k *KeyVault) Name() string {
 	return k.vaultName
 }
 
-// Setup creates a new Azure Key Vault.
-func Setup(ctx context.Context, props *properties.Properties, workspace string) (*KeyVault, error) {
+// Setup creates a new Azure Key Vault. creds supplies the management- and
+// vault-endpoint authorizers and the caller identity to grant access to,
+// resolved ahead of time by the auth package so this package never has to
+// know how the caller authenticated (CLI, service principal, MSI, ...).
+// authMode selects whether the operator is granted access via a classic
+// access policy or an RBAC role assignment. retryPolicy governs how the
+// vault's secret data-plane calls (see secrets.go) retry transient errors.
+func Setup(ctx context.Context, props *properties.Properties, creds *auth.Credentials, workspace string, authMode AuthorizationMode, retryPolicy retry.Policy) (*KeyVault, error) {
 	k := &KeyVault{
-		resourceGroupName: props.Name,
-		vaultClient:       keyvault.NewVaultsClient(props.SubscriptionID),
+		resourceGroupName: props.ResourceGroupName,
+		vaultClient:       keyvault.NewVaultsClient(creds.SubscriptionID),
 		keyClient:         KV.New(),
+		roleClient:        authorization.NewRoleAssignmentsClient(creds.SubscriptionID),
 		workspace:         workspace,
 		location:          props.Location,
+		authMode:          authMode,
+		retryPolicy:       retryPolicy,
 	}
-	k.vaultClient.Authorizer = props.MgmtAuthorizer
+	k.vaultClient.Authorizer = creds.MgmtAuthorizer
+	k.keyClient.Authorizer = creds.VaultAuthorizer
+	k.roleClient.Authorizer = creds.MgmtAuthorizer
 
 	// Set a new generated key vault name.
-	k.vaultName = props.Name + workspace
+	k.vaultName = props.KeyVaultPrefix + workspace
 
-	// Setup the key vault.
-	accessPolicies := []keyvault.AccessPolicyEntry{
-		keyvault.AccessPolicyEntry{
-			TenantID: &props.TenantID,
-			ObjectID: &props.ObjectID,
-			Permissions: &keyvault.Permissions{
-				Secrets: &[]keyvault.SecretPermissions{
-					keyvault.SecretPermissionsList,
-					keyvault.SecretPermissionsGet,
-					keyvault.SecretPermissionsSet,
-					keyvault.SecretPermissionsDelete,
-				},
+	// Under the classic access-policy model the operator is granted access
+	// directly on the vault; under RBAC it's granted via a role assignment
+	// once the vault exists, so no access policies are set at creation time.
+	var accessPolicies []keyvault.AccessPolicyEntry
+	if authMode == AccessPolicy {
+		accessPolicies = []keyvault.AccessPolicyEntry{
+			keyvault.AccessPolicyEntry{
+				TenantID:    &creds.TenantID,
+				ObjectID:    &creds.ObjectID,
+				Permissions: &keyvault.Permissions{Secrets: accessPolicyPermissions(Admin)},
 			},
-		},
+		}
 	}
-	vault, err := k.vaultClient.Get(ctx, props.Name, k.vaultName)
+	vault, err := k.vaultClient.Get(ctx, props.ResourceGroupName, k.vaultName)
 	if err != nil {
-		vault, err = k.vaultClient.CreateOrUpdate(ctx, props.Name, k.vaultName, keyvault.VaultCreateOrUpdateParameters{
-			Location: to.StringPtr(props.Location),
-			Properties: &keyvault.VaultProperties{
-				TenantID: &props.TenantID,
-				Sku: &keyvault.Sku{
-					Family: to.StringPtr("A"),
-					Name:   keyvault.Standard,
-				},
-				AccessPolicies: &accessPolicies,
-			},
-		})
+		createParams := vaultCreateParams(props, authMode, accessPolicies)
+
+		// A vault with the same name may still be tombstoned in the tenant's
+		// soft-delete graveyard. If so, recover it instead of creating a fresh
+		// one, since same-name creation is rejected while a tombstone exists.
+		deletedVault, derr := k.findDeletedVault(ctx, props.ResourceGroupName)
+		if derr == nil && deletedVault != nil {
+			if deletedVault.Properties == nil || deletedVault.Properties.Location == nil || *deletedVault.Properties.Location != props.Location {
+				return nil, fmt.Errorf("found a soft-deleted key vault %q but its location does not match %q; refusing to recover", k.vaultName, props.Location)
+			}
+			createParams.Properties.CreateMode = keyvault.CreateModeRecover
+		}
+
+		vault, err = k.createOrUpdateWithRetry(ctx, createParams)
 		if err != nil {
 			return nil, fmt.Errorf("error creating key vault: %s", err)
 		}
-	} else {
+	} else if authMode == AccessPolicy {
 		found := false
 		for _, policy := range *vault.Properties.AccessPolicies {
-			if *policy.ObjectID == props.ObjectID {
+			if *policy.ObjectID == creds.ObjectID {
 				found = true
 				break
 			}
@@ -95,22 +130,67 @@ func Setup(ctx context.Context, props *properties.Properties, workspace string)
 		}
 	}
 	k.vaultURI = *vault.Properties.VaultURI
+	k.vaultID = *vault.ID
 
-	const vaultEndpoint = "https://vault.azure.net"
-	if k.keyClient.Authorizer, err = azauth.NewAuthorizerFromCLIWithResource(vaultEndpoint); err != nil {
-		return nil, fmt.Errorf("error creating new authorizer from CLI with resource %s: %v", vaultEndpoint, err)
+	if authMode == RBAC {
+		if err := k.assignRole(ctx, creds.ObjectID, Admin); err != nil {
+			return nil, fmt.Errorf("error granting the operator access via RBAC: %s", err)
+		}
 	}
+
 	return k, nil
 }
 
+// vaultCreateParams builds the parameters for creating the state key vault.
+// KeyVaultSKU, SoftDeleteRetentionDays, and PurgeProtection fall back to
+// "standard", 90 days, and true respectively when unset, matching the
+// schema's own documented defaults.
+func vaultCreateParams(props *properties.Properties, authMode AuthorizationMode, accessPolicies []keyvault.AccessPolicyEntry) keyvault.VaultCreateOrUpdateParameters {
+	vaultSKU := keyvault.Standard
+	if props.KeyVaultSKU == string(keyvault.Premium) {
+		vaultSKU = keyvault.Premium
+	}
+	softDeleteRetentionDays := props.SoftDeleteRetentionDays
+	if softDeleteRetentionDays == 0 {
+		softDeleteRetentionDays = 90
+	}
+	purgeProtection := true
+	if props.PurgeProtection != nil {
+		purgeProtection = *props.PurgeProtection
+	}
+
+	return keyvault.VaultCreateOrUpdateParameters{
+		Location: to.StringPtr(props.Location),
+		Properties: &keyvault.VaultProperties{
+			TenantID: &props.TenantID,
+			Sku: &keyvault.Sku{
+				Family: to.StringPtr("A"),
+				Name:   vaultSKU,
+			},
+			AccessPolicies:            &accessPolicies,
+			EnableRbacAuthorization:   to.BoolPtr(authMode == RBAC),
+			EnableSoftDelete:          to.BoolPtr(true),
+			EnablePurgeProtection:     to.BoolPtr(purgeProtection),
+			CreateMode:                keyvault.CreateModeDefault,
+			SoftDeleteRetentionInDays: to.Int32Ptr(softDeleteRetentionDays),
+		},
+	}
+}
+
 // ManagedIdentity contains the ID of a managed service principal.
 type ManagedIdentity struct {
 	PrincipalID string
 	TenantID    string
 }
 
-// AddIDToAccessPolicies adds a managed identity to the key vault's access policies.
-func (k *KeyVault) AddIDToAccessPolicies(ctx context.Context, identity *ManagedIdentity) error {
+// AddIDToAccessPolicies grants a managed identity access to the key vault's
+// secrets according to profile, via a classic access policy or an RBAC role
+// assignment depending on the vault's AuthorizationMode.
+func (k *KeyVault) AddIDToAccessPolicies(ctx context.Context, identity *ManagedIdentity, profile PermissionProfile) error {
+	if k.authMode == RBAC {
+		return k.assignRole(ctx, identity.PrincipalID, profile)
+	}
+
 	tenantID, err := uuid.FromString(identity.TenantID)
 	if err != nil {
 		return fmt.Errorf("error converting tenant ID-string to UUID: %s", err)
@@ -119,13 +199,9 @@ func (k *KeyVault) AddIDToAccessPolicies(ctx context.Context, identity *ManagedI
 		Properties: &keyvault.VaultAccessPolicyProperties{
 			AccessPolicies: &[]keyvault.AccessPolicyEntry{
 				keyvault.AccessPolicyEntry{
-					TenantID: &tenantID,
-					ObjectID: &identity.PrincipalID,
-					Permissions: &keyvault.Permissions{
-						Secrets: &[]keyvault.SecretPermissions{
-							keyvault.SecretPermissionsGet,
-						},
-					},
+					TenantID:    &tenantID,
+					ObjectID:    &identity.PrincipalID,
+					Permissions: &keyvault.Permissions{Secrets: accessPolicyPermissions(profile)},
 				},
 			},
 		},
@@ -137,6 +213,10 @@ func (k *KeyVault) AddIDToAccessPolicies(ctx context.Context, identity *ManagedI
 
 // RemoveIDFromAccessPolicies removes the service principal ID provided from the key vault's access policies.
 func (k *KeyVault) RemoveIDFromAccessPolicies(ctx context.Context, tenantID uuid.UUID, objectID string) error {
+	if k.authMode == RBAC {
+		return k.removeRoleAssignment(ctx, objectID)
+	}
+
 	if _, err := k.vaultClient.UpdateAccessPolicy(ctx, k.resourceGroupName, k.vaultName, keyvault.Remove, keyvault.VaultAccessPolicyParameters{
 		Properties: &keyvault.VaultAccessPolicyProperties{
 			AccessPolicies: &[]keyvault.AccessPolicyEntry{
@@ -152,12 +232,165 @@ func (k *KeyVault) RemoveIDFromAccessPolicies(ctx context.Context, tenantID uuid
 	return nil
 }
 
-// GetAccessPolicies returns the access policies of the key vault.
+// AccessPolicyGrant pairs a managed identity with the permission profile it
+// should be granted on the vault, as resolved from an access_policy {}
+// block's configured resource_address/permissions.
+type AccessPolicyGrant struct {
+	Identity ManagedIdentity
+	Profile  PermissionProfile
+}
+
+// ProfileFromPermissions translates an access_policy {} block's raw
+// permissions list ("get", "list", "set", "delete") into the closest
+// PermissionProfile: Admin if delete is requested, else Writer if set is
+// requested, else Reader.
+func ProfileFromPermissions(permissions []string) PermissionProfile {
+	profile := Reader
+	for _, permission := range permissions {
+		switch permission {
+		case "delete":
+			return Admin
+		case "set":
+			profile = Writer
+		}
+	}
+	return profile
+}
+
+// SetAccessPolicies reconciles the vault's access so it grants exactly
+// grants, plus operator (the caller's own identity, always kept with Admin
+// access so the operator isn't locked out of its own vault). Under
+// AuthorizationMode AccessPolicy this is a single batched
+// UpdateAccessPolicy(Replace) call that replaces the vault's whole access
+// policy list, rather than one call per identity added or removed. Under
+// RBAC, which has no list-replace equivalent, each added/removed identity is
+// instead reconciled via its own role assignment call. Returns the object
+// IDs added and removed relative to the vault's current access, for the
+// caller to log.
+func (k *KeyVault) SetAccessPolicies(ctx context.Context, operator ManagedIdentity, grants []AccessPolicyGrant) (added, removed []string, err error) {
+	// Under RBAC the vault's access-policy list (what GetAccessPolicies
+	// reads) is always empty — access lives in role assignments instead —
+	// so the "current" set has to come from actually enumerating those,
+	// otherwise every grant below would look newly "added" forever and a
+	// revoked grant would never be detected as "removed".
+	currentIDs := make(map[string]bool)
+	if k.authMode == RBAC {
+		currentIDs, err = k.rbacGrantedPrincipals(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting the vault's current RBAC role assignments: %s", err)
+		}
+	} else {
+		current, err := k.GetAccessPolicies(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting the key vault's access policies: %s", err)
+		}
+		for _, policy := range current {
+			if policy.ObjectID != nil {
+				currentIDs[*policy.ObjectID] = true
+			}
+		}
+	}
+
+	desired := make(map[string]AccessPolicyGrant, len(grants))
+	for _, grant := range grants {
+		desired[grant.Identity.PrincipalID] = grant
+	}
+	for objectID := range currentIDs {
+		if objectID == operator.PrincipalID {
+			continue
+		}
+		if _, ok := desired[objectID]; !ok {
+			removed = append(removed, objectID)
+		}
+	}
+	for objectID, grant := range desired {
+		if !currentIDs[objectID] {
+			added = append(added, grant.Identity.PrincipalID)
+		}
+	}
+
+	if k.authMode == RBAC {
+		for _, objectID := range removed {
+			if err := k.removeRoleAssignment(ctx, objectID); err != nil {
+				return nil, nil, fmt.Errorf("error revoking RBAC access for %s: %s", objectID, err)
+			}
+		}
+		for _, objectID := range added {
+			if err := k.assignRole(ctx, objectID, desired[objectID].Profile); err != nil {
+				return nil, nil, fmt.Errorf("error granting RBAC access for %s: %s", objectID, err)
+			}
+		}
+		return added, removed, nil
+	}
+
+	operatorTenantID, err := uuid.FromString(operator.TenantID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error converting operator tenant ID-string to UUID: %s", err)
+	}
+	entries := []keyvault.AccessPolicyEntry{
+		{
+			TenantID:    &operatorTenantID,
+			ObjectID:    &operator.PrincipalID,
+			Permissions: &keyvault.Permissions{Secrets: accessPolicyPermissions(Admin)},
+		},
+	}
+	for _, grant := range grants {
+		tenantID, err := uuid.FromString(grant.Identity.TenantID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error converting tenant ID-string to UUID for %s: %s", grant.Identity.PrincipalID, err)
+		}
+		entries = append(entries, keyvault.AccessPolicyEntry{
+			TenantID:    &tenantID,
+			ObjectID:    &grant.Identity.PrincipalID,
+			Permissions: &keyvault.Permissions{Secrets: accessPolicyPermissions(grant.Profile)},
+		})
+	}
+	if _, err := k.vaultClient.UpdateAccessPolicy(ctx, k.resourceGroupName, k.vaultName, keyvault.Replace, keyvault.VaultAccessPolicyParameters{
+		Properties: &keyvault.VaultAccessPolicyProperties{AccessPolicies: &entries},
+	}); err != nil {
+		return nil, nil, fmt.Errorf("error replacing the key vault's access policies: %s", err)
+	}
+	return added, removed, nil
+}
+
+// rbacGrantedPrincipals returns the object IDs of every identity currently
+// holding an RBAC role assignment scoped to this vault. SetAccessPolicies
+// uses this, instead of GetAccessPolicies, to compute its current/desired
+// diff under AuthorizationMode RBAC, since the vault's access-policy list
+// is always empty in that mode.
+func (k *KeyVault) rbacGrantedPrincipals(ctx context.Context) (map[string]bool, error) {
+	granted := make(map[string]bool)
+	result, err := k.roleClient.ListForScope(ctx, k.vaultID, "")
+	if err != nil {
+		return nil, fmt.Errorf("error listing role assignments: %s", err)
+	}
+	for result.NotDone() {
+		for _, assignment := range result.Values() {
+			if assignment.Properties != nil && assignment.Properties.PrincipalID != nil {
+				granted[*assignment.Properties.PrincipalID] = true
+			}
+		}
+		if err := result.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("error paging role assignments: %s", err)
+		}
+	}
+	return granted, nil
+}
+
+// GetAccessPolicies returns the access policies of the key vault. Under
+// AuthorizationMode RBAC the vault has no access policies (access is
+// managed via role assignments instead), so this always returns an empty
+// list; callers that need a mode-aware view of the vault's current access
+// should use rbacGrantedPrincipals under RBAC instead, as SetAccessPolicies
+// does.
 func (k *KeyVault) GetAccessPolicies(ctx context.Context) ([]keyvault.AccessPolicyEntry, error) {
 	vault, err := k.vaultClient.Get(ctx, k.resourceGroupName, k.vaultName)
 	if err != nil {
 		return nil, fmt.Errorf("error getting access policies: %s", err)
 	}
+	if vault.Properties.AccessPolicies == nil {
+		return nil, nil
+	}
 	return *vault.Properties.AccessPolicies, nil
 }
 
@@ -168,3 +401,234 @@ func (k *KeyVault) Delete(ctx context.Context) error {
 	}
 	return nil
 }
+
+// DeleteWithPurge deletes the key vault and then permanently purges the
+// soft-deleted tombstone it leaves behind. This is irreversible: the vault
+// and every secret version stored in it are gone for good, so it must only
+// be called when the caller has explicitly opted into purging (e.g. via a
+// backend config flag), never as the default destroy path.
+func (k *KeyVault) DeleteWithPurge(ctx context.Context) error {
+	if err := k.Delete(ctx); err != nil {
+		return err
+	}
+
+	// Poll until the tombstone shows up in the deleted-vaults listing, then purge it.
+	const (
+		pollInterval = 5 * time.Second
+		pollTimeout  = 5 * time.Minute
+	)
+	deadline := time.Now().Add(pollTimeout)
+	for {
+		deletedVault, err := k.findDeletedVault(ctx, k.resourceGroupName)
+		if err != nil {
+			return fmt.Errorf("error polling for deleted key vault: %s", err)
+		}
+		if deletedVault != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for key vault %q to appear in the deleted-vaults listing", k.vaultName)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	if err := k.Purge(ctx); err != nil {
+		return fmt.Errorf("error purging key vault: %s", err)
+	}
+	return nil
+}
+
+// Purge permanently removes a soft-deleted key vault so that its name can be
+// reused immediately. This is irreversible and must be gated behind an
+// explicit user opt-in at the call site.
+func (k *KeyVault) Purge(ctx context.Context) error {
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		future, err := k.vaultClient.PurgeDeleted(ctx, k.vaultName, k.location)
+		if err == nil {
+			if err := future.WaitForCompletionRef(ctx, k.vaultClient.Client); err != nil {
+				return fmt.Errorf("error waiting for key vault purge to complete: %s", err)
+			}
+			return nil
+		}
+		if !isConflict(err) {
+			return fmt.Errorf("error purging deleted key vault: %s", err)
+		}
+		lastErr = err
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+	return fmt.Errorf("error purging deleted key vault after retries: %s", lastErr)
+}
+
+// findDeletedVault looks up a soft-deleted vault named after the given
+// resource-group-scoped prefix in the tenant's deleted-vault graveyard.
+// It returns nil, nil if no matching tombstone exists.
+func (k *KeyVault) findDeletedVault(ctx context.Context, resourceGroupName string) (*keyvault.DeletedVault, error) {
+	result, err := k.vaultClient.ListDeleted(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deleted key vaults: %s", err)
+	}
+	for result.NotDone() {
+		for _, dv := range result.Values() {
+			if dv.Name != nil && *dv.Name == k.vaultName {
+				return &dv, nil
+			}
+		}
+		if err := result.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("error paging deleted key vaults: %s", err)
+		}
+	}
+	return nil, nil
+}
+
+// createOrUpdateWithRetry calls CreateOrUpdate, retrying on 409 conflicts
+// while a preceding async delete/recover operation is still completing.
+func (k *KeyVault) createOrUpdateWithRetry(ctx context.Context, params keyvault.VaultCreateOrUpdateParameters) (keyvault.Vault, error) {
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		vault, err := k.vaultClient.CreateOrUpdate(ctx, k.resourceGroupName, k.vaultName, params)
+		if err == nil {
+			return vault, nil
+		}
+		if !isConflict(err) {
+			return keyvault.Vault{}, err
+		}
+		lastErr = err
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+	return keyvault.Vault{}, lastErr
+}
+
+// isConflict reports whether err is an HTTP 409 returned by the ARM API,
+// which happens while a preceding delete/recover/purge is still in flight.
+func isConflict(err error) bool {
+	if detailed, ok := err.(autorest.DetailedError); ok {
+		return detailed.StatusCode == 409
+	}
+	return false
+}
+
+// PermissionProfile is a well-known permission bundle that callers request
+// instead of assembling raw permission arrays. It's translated to either
+// classic access-policy permissions or an RBAC role depending on the
+// vault's AuthorizationMode, so a single call site works for both.
+type PermissionProfile string
+
+const (
+	// Reader can list and get secrets.
+	Reader PermissionProfile = "Reader"
+	// Writer can additionally set secrets.
+	Writer PermissionProfile = "Writer"
+	// Admin can additionally delete secrets and manage the vault's own access policies/roles.
+	Admin PermissionProfile = "Admin"
+)
+
+// Well-known built-in role definition IDs, constant across all subscriptions.
+// See: https://learn.microsoft.com/azure/role-based-access-control/built-in-roles
+const (
+	roleKeyVaultSecretsUser    = "4633458b-17de-408a-b874-0445c86b69e6" // Key Vault Secrets User
+	roleKeyVaultSecretsOfficer = "b86a8fe4-44ce-4948-aee5-eccb2c155cd7" // Key Vault Secrets Officer
+	roleKeyVaultCryptoUser     = "12338af0-0e69-4776-bea7-57ae8d297424" // Key Vault Crypto User
+)
+
+// accessPolicyPermissions translates profile to the classic access-policy
+// secret permissions it grants.
+func accessPolicyPermissions(profile PermissionProfile) *[]keyvault.SecretPermissions {
+	permissions := []keyvault.SecretPermissions{keyvault.SecretPermissionsList, keyvault.SecretPermissionsGet}
+	if profile == Writer || profile == Admin {
+		permissions = append(permissions, keyvault.SecretPermissionsSet)
+	}
+	if profile == Admin {
+		permissions = append(permissions, keyvault.SecretPermissionsDelete)
+	}
+	return &permissions
+}
+
+// roleDefinitionID translates profile to the RBAC role it grants: Reader
+// maps to Key Vault Secrets User (get/list only), Writer and Admin both map
+// to Key Vault Secrets Officer (read/write secret values, plus delete and
+// vault-local secret management), matching what accessPolicyPermissions
+// grants Writer under AccessPolicy mode.
+func roleDefinitionID(subscriptionID string, profile PermissionProfile) string {
+	role := roleKeyVaultSecretsUser
+	if profile == Writer || profile == Admin {
+		role = roleKeyVaultSecretsOfficer
+	}
+	return fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", subscriptionID, role)
+}
+
+// assignRole grants principalID the RBAC role matching profile, scoped to this vault.
+func (k *KeyVault) assignRole(ctx context.Context, principalID string, profile PermissionProfile) error {
+	return k.assignRoleID(ctx, principalID, roleDefinitionID(k.vaultClient.SubscriptionID, profile))
+}
+
+// GrantCryptoUser grants principalID the built-in "Key Vault Crypto User"
+// RBAC role on this vault, which is needed (on top of Key Vault Secrets
+// Officer) to wrap/unwrap data-encryption keys via WrapKey/UnwrapKey and to
+// create them via GetOrCreateKey. It's a no-op under AuthorizationMode
+// AccessPolicy, which grants key permissions through the vault's access
+// policy list instead of RBAC role assignments.
+func (k *KeyVault) GrantCryptoUser(ctx context.Context, principalID string) error {
+	if k.authMode != RBAC {
+		return nil
+	}
+	roleID := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", k.vaultClient.SubscriptionID, roleKeyVaultCryptoUser)
+	return k.assignRoleID(ctx, principalID, roleID)
+}
+
+// assignRoleID grants principalID the RBAC role identified by
+// roleDefinitionID, scoped to this vault.
+func (k *KeyVault) assignRoleID(ctx context.Context, principalID, roleDefinitionID string) error {
+	name, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating role assignment name: %s", err)
+	}
+	if _, err := k.roleClient.Create(ctx, k.vaultID, name.String(), authorization.RoleAssignmentCreateParameters{
+		Properties: &authorization.RoleAssignmentProperties{
+			RoleDefinitionID: to.StringPtr(roleDefinitionID),
+			PrincipalID:      to.StringPtr(principalID),
+		},
+	}); err != nil {
+		return fmt.Errorf("error creating role assignment: %s", err)
+	}
+	return nil
+}
+
+// isManagedRole reports whether roleDefinitionID (a full ARM resource ID)
+// names one of the built-in roles this package itself grants via assignRole/
+// GrantCryptoUser: Key Vault Secrets User, Key Vault Secrets Officer, or Key
+// Vault Crypto User.
+func isManagedRole(roleDefinitionID string) bool {
+	for _, role := range []string{roleKeyVaultSecretsUser, roleKeyVaultSecretsOfficer, roleKeyVaultCryptoUser} {
+		if strings.HasSuffix(roleDefinitionID, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeRoleAssignment revokes principalID's role assignments on this vault,
+// limited to the built-in roles this package itself grants (see
+// isManagedRole). A principal that also holds some unrelated role on the
+// vault — e.g. one assigned directly by an administrator for break-glass
+// access — keeps that assignment.
+func (k *KeyVault) removeRoleAssignment(ctx context.Context, principalID string) error {
+	result, err := k.roleClient.ListForScope(ctx, k.vaultID, fmt.Sprintf("principalId eq '%s'", principalID))
+	if err != nil {
+		return fmt.Errorf("error listing role assignments: %s", err)
+	}
+	for result.NotDone() {
+		for _, assignment := range result.Values() {
+			if assignment.Properties == nil || assignment.Properties.RoleDefinitionID == nil || !isManagedRole(*assignment.Properties.RoleDefinitionID) {
+				continue
+			}
+			if _, err := k.roleClient.DeleteByID(ctx, *assignment.ID); err != nil {
+				return fmt.Errorf("error deleting role assignment %q: %s", *assignment.ID, err)
+			}
+		}
+		if err := result.NextWithContext(ctx); err != nil {
+			return fmt.Errorf("error paging role assignments: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package keyvault
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/mgmt/2016-10-01/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
+)
+
+func TestVaultCreateParamsDefaults(t *testing.T) {
+	params := vaultCreateParams(&properties.Properties{}, RBAC, nil)
+	if params.Properties.Sku == nil || params.Properties.Sku.Name != keyvault.Standard {
+		t.Errorf("got SKU %v, want %s", params.Properties.Sku, keyvault.Standard)
+	}
+	if *params.Properties.SoftDeleteRetentionInDays != 90 {
+		t.Errorf("got SoftDeleteRetentionInDays %d, want 90", *params.Properties.SoftDeleteRetentionInDays)
+	}
+	if !*params.Properties.EnablePurgeProtection {
+		t.Error("EnablePurgeProtection should default to true")
+	}
+}
+
+// TestVaultCreateParamsBootstrap asserts that a bootstrap {} block's
+// non-default values (KeyVaultSKU, SoftDeleteRetentionDays, PurgeProtection,
+// as wired by Backend.configure) actually change the
+// VaultCreateOrUpdateParameters keyvault.Setup creates the vault with.
+func TestVaultCreateParamsBootstrap(t *testing.T) {
+	noPurgeProtection := false
+	params := vaultCreateParams(&properties.Properties{
+		KeyVaultSKU:             string(keyvault.Premium),
+		SoftDeleteRetentionDays: 42,
+		PurgeProtection:         &noPurgeProtection,
+	}, RBAC, nil)
+
+	if params.Properties.Sku == nil || params.Properties.Sku.Name != keyvault.Premium {
+		t.Errorf("KeyVaultSKU override did not take effect: got %v, want %s", params.Properties.Sku, keyvault.Premium)
+	}
+	if *params.Properties.SoftDeleteRetentionInDays != 42 {
+		t.Errorf("SoftDeleteRetentionDays override did not take effect: got %d, want 42", *params.Properties.SoftDeleteRetentionInDays)
+	}
+	if *params.Properties.EnablePurgeProtection {
+		t.Error("PurgeProtection override did not take effect: EnablePurgeProtection still true")
+	}
+}
+
+func TestIsManagedRole(t *testing.T) {
+	const subscriptionID = "00000000-0000-0000-0000-000000000000"
+	managed := []string{roleKeyVaultSecretsUser, roleKeyVaultSecretsOfficer, roleKeyVaultCryptoUser}
+	for _, role := range managed {
+		id := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", subscriptionID, role)
+		if !isManagedRole(id) {
+			t.Errorf("isManagedRole(%q) = false, want true", id)
+		}
+	}
+
+	const breakGlassAdminRole = "8e3af657-a8ff-443c-a75c-2fe8c4bcb635" // Owner
+	id := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", subscriptionID, breakGlassAdminRole)
+	if isManagedRole(id) {
+		t.Errorf("isManagedRole(%q) = true, want false: this role isn't one removeRoleAssignment should ever delete", id)
+	}
+}
@@ -0,0 +1,80 @@
+package keyvault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	KV "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// GetOrCreateKey returns the vault URI and name of an RSA key in the vault,
+// creating it the first time it's needed. Consumers that reference
+// customer-managed keys by vault URI and key name (e.g. Storage Account
+// encryption) don't need the key's version back, since they're expected to
+// always resolve the latest version themselves.
+func (k *KeyVault) GetOrCreateKey(ctx context.Context, name string) (vaultURI, keyName string, err error) {
+	if _, err := k.keyClient.GetKey(ctx, k.vaultURI, name, ""); err == nil {
+		return k.vaultURI, name, nil
+	}
+
+	keySize := int32(2048)
+	if _, err := k.keyClient.CreateKey(ctx, k.vaultURI, name, KV.KeyCreateParameters{
+		Kty:     KV.RSA,
+		KeySize: &keySize,
+	}); err != nil {
+		return "", "", fmt.Errorf("error creating key %q: %s", name, err)
+	}
+	return k.vaultURI, name, nil
+}
+
+// WrapKey encrypts (wraps) dek with the named key's public key using
+// RSA-OAEP-256, creating the key the first time it's needed (see
+// GetOrCreateKey). keyVersion "" wraps under the key's current version.
+// It returns the fully-qualified key ID ("kid") the DEK was wrapped under,
+// so callers can record it alongside the ciphertext and pin decryption to
+// that exact version later, even after the key has since been rotated.
+func (k *KeyVault) WrapKey(ctx context.Context, keyName, keyVersion string, dek []byte) (kid string, wrapped []byte, err error) {
+	if _, _, err := k.GetOrCreateKey(ctx, keyName); err != nil {
+		return "", nil, fmt.Errorf("error ensuring encryption key %q exists: %s", keyName, err)
+	}
+
+	result, err := k.keyClient.Encrypt(ctx, k.vaultURI, keyName, keyVersion, KV.KeyOperationsParameters{
+		Algorithm: KV.RSAOAEP256,
+		Value:     &dek,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error wrapping key with %q: %s", keyName, err)
+	}
+	return *result.Kid, *result.Result, nil
+}
+
+// UnwrapKey decrypts (unwraps) a DEK previously wrapped by WrapKey. kid is
+// the fully-qualified key ID returned by WrapKey at the time of wrapping,
+// so decryption always targets the exact key version the DEK was wrapped
+// under, keeping old snapshots readable across key rotations.
+func (k *KeyVault) UnwrapKey(ctx context.Context, kid string, wrapped []byte) ([]byte, error) {
+	keyName, keyVersion := keyNameAndVersionFromKid(kid)
+	if keyName == "" {
+		return nil, fmt.Errorf("malformed key ID %q", kid)
+	}
+	result, err := k.keyClient.Decrypt(ctx, k.vaultURI, keyName, keyVersion, KV.KeyOperationsParameters{
+		Algorithm: KV.RSAOAEP256,
+		Value:     &wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping key %q: %s", kid, err)
+	}
+	return *result.Result, nil
+}
+
+// keyNameAndVersionFromKid splits a fully-qualified key ID
+// ("https://<vault>.vault.azure.net/keys/<name>/<version>") into its name
+// and version components.
+func keyNameAndVersionFromKid(kid string) (name, version string) {
+	parts := strings.Split(strings.TrimPrefix(kid, "https://"), "/")
+	if len(parts) < 4 || parts[1] != "keys" {
+		return "", ""
+	}
+	return parts[2], parts[3]
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	KV "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/retry"
 )
 
 // getID gets the secret name (ID without the base URI) from the key vault's ID.
@@ -20,8 +21,11 @@ func getID(ID string) string {
 func (k *KeyVault) SetSecret(ctx context.Context, name, value string, tags map[string]*string) (string, error) {
 	// Get latest secret.
 	var maxResults int32 = 1
-	result, err := k.keyClient.GetSecretVersions(ctx, k.vaultURI, name, &maxResults)
-	if err != nil {
+	var result KV.SecretListResultIterator
+	if err := retry.Do(k.retryPolicy, retry.AzureTransient, func() (err error) {
+		result, err = k.keyClient.GetSecretVersions(ctx, k.vaultURI, name, &maxResults)
+		return err
+	}); err != nil {
 		return "", fmt.Errorf("error getting secret versions: %s", err)
 	}
 	values := result.Values()
@@ -39,8 +43,11 @@ func (k *KeyVault) SetSecret(ctx context.Context, name, value string, tags map[s
 
 	// Set/insert a new secret.
 	contentType := "text/plain;charset=UTF-8"
-	bundle, err := k.keyClient.SetSecret(ctx, k.vaultURI, name, KV.SecretSetParameters{Value: &value, ContentType: &contentType, Tags: tags})
-	if err != nil {
+	var bundle KV.SecretBundle
+	if err := retry.Do(k.retryPolicy, retry.AzureTransient, func() (err error) {
+		bundle, err = k.keyClient.SetSecret(ctx, k.vaultURI, name, KV.SecretSetParameters{Value: &value, ContentType: &contentType, Tags: tags})
+		return err
+	}); err != nil {
 		return "", fmt.Errorf("error inserting secret: %s", err)
 	}
 
@@ -48,6 +55,19 @@ func (k *KeyVault) SetSecret(ctx context.Context, name, value string, tags map[s
 	return getID(*bundle.ID), nil
 }
 
+// RotateSecret re-inserts a secret's value under a brand new version, even
+// when the value is unchanged, unlike SetSecret which skips the insert in
+// that case. Used to re-encrypt state attributes under a fresh version
+// without changing their plaintext.
+func (k *KeyVault) RotateSecret(ctx context.Context, name, value string, tags map[string]*string) (string, error) {
+	contentType := "text/plain;charset=UTF-8"
+	bundle, err := k.keyClient.SetSecret(ctx, k.vaultURI, name, KV.SecretSetParameters{Value: &value, ContentType: &contentType, Tags: tags})
+	if err != nil {
+		return "", fmt.Errorf("error rotating secret: %s", err)
+	}
+	return getID(*bundle.ID), nil
+}
+
 // DeleteSecret deletes the secret named after the given name-parameter.
 func (k *KeyVault) DeleteSecret(ctx context.Context, name string) error {
 	_, err := k.keyClient.DeleteSecret(ctx, k.vaultURI, name)
@@ -56,8 +76,11 @@ func (k *KeyVault) DeleteSecret(ctx context.Context, name string) error {
 
 // GetSecret gets the secret named name from the key vault.
 func (k *KeyVault) GetSecret(ctx context.Context, name string, version string) (string, error) {
-	bundle, err := k.keyClient.GetSecret(ctx, k.vaultURI, name, version)
-	if err != nil {
+	var bundle KV.SecretBundle
+	if err := retry.Do(k.retryPolicy, retry.AzureTransient, func() (err error) {
+		bundle, err = k.keyClient.GetSecret(ctx, k.vaultURI, name, version)
+		return err
+	}); err != nil {
 		return "", fmt.Errorf("error getting secret: %s", err)
 	}
 	return *bundle.Value, nil
@@ -70,8 +93,11 @@ type SecretMetadata struct {
 
 // ListSecrets returns the names of the secrets.
 func (k *KeyVault) ListSecrets(ctx context.Context) (map[string]SecretMetadata, error) {
-	secrets, err := k.keyClient.GetSecrets(ctx, k.vaultURI, nil)
-	if err != nil {
+	var secrets KV.SecretListResultIterator
+	if err := retry.Do(k.retryPolicy, retry.AzureTransient, func() (err error) {
+		secrets, err = k.keyClient.GetSecrets(ctx, k.vaultURI, nil)
+		return err
+	}); err != nil {
 		return nil, fmt.Errorf("error getting secrets from key vault: %s", err)
 	}
 	secretMap := make(map[string]SecretMetadata)
@@ -85,7 +111,9 @@ func (k *KeyVault) ListSecrets(ctx context.Context) (map[string]SecretMetadata,
 				Tags: value.Tags,
 			}
 		}
-		if err := secrets.NextWithContext(ctx); err != nil {
+		if err := retry.Do(k.retryPolicy, retry.AzureTransient, func() error {
+			return secrets.NextWithContext(ctx)
+		}); err != nil {
 			break
 		}
 	}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"sync"
 
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/common"
-	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/secretstore"
 	"github.com/hashicorp/terraform/configs/configload"
 	"github.com/hashicorp/terraform/configs/configschema"
 	"github.com/hashicorp/terraform/providers"
@@ -77,7 +79,7 @@ func (s *State) mask(r *common.ResourceState) error {
 		resourceSchemas = append(resourceSchemas, schema.Block)
 	}
 
-	// Mask the sensitive resource attributes by moving them to the key vault.
+	// Mask the sensitive resource attributes by moving them to the secret store.
 	for _, schema := range resourceSchemas {
 		for i := range r.Instances {
 			instance := &r.Instances[i]
@@ -86,7 +88,7 @@ func (s *State) mask(r *common.ResourceState) error {
 			if err = json.Unmarshal(instance.AttributesRaw, &attributes); err != nil {
 				return fmt.Errorf("error unmarshalling attributes: %s", err)
 			}
-			if err = s.maskAttributes(r.Module, r.Name, attributes, schema); err != nil {
+			if err = s.maskAttributes(r.Module, r.Type, r.Name, attributes, schema, nil); err != nil {
 				return fmt.Errorf("error masking attributes: %s", err)
 			}
 			if instance.AttributesRaw, err = json.Marshal(attributes); err != nil {
@@ -98,13 +100,62 @@ func (s *State) mask(r *common.ResourceState) error {
 	return nil
 }
 
-// maskAttributes masks the attributes of a resource.
-func (s *State) maskAttributes(moduleName, resourceName string, attributes map[string]interface{}, schema *configschema.Block) error {
+// attributeNameIsSensitive reports whether attributeName was explicitly
+// configured as sensitive via SensitiveAttributePatterns, matched either
+// against the bare attribute name or "<resource type>.<attribute name>",
+// so operators can mask attributes the provider schema doesn't mark
+// Sensitive (e.g. a custom field that happens to hold a token).
+func (s *State) attributeNameIsSensitive(resourceType, attributeName string) bool {
+	qualified := resourceType + "." + attributeName
+	for _, pattern := range s.SensitiveAttributePatterns {
+		if pattern.MatchString(attributeName) || pattern.MatchString(qualified) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretTagsMatch reports whether a and b identify the same logical
+// attribute slot: the same module/resource/attribute, and — whenever
+// either side has an "index"/"key" tag (set for attributes nested in a
+// list or map attribute, respectively) or a "block_index"/"block_key" tag
+// (set for attributes nested in a NestingList/NestingSet or NestingMap
+// block, respectively) — the same one. Without these comparisons, every
+// entry of a sensitive list/map attribute, or every instance of a repeated
+// nested block, beyond the first would collide onto whichever secret
+// happened to match on module/resource/attribute alone, silently reusing
+// one secret name for multiple distinct values.
+func secretTagsMatch(a, b map[string]*string) bool {
+	for _, name := range []string{"module", "resource", "attribute", "index", "key", "block_index", "block_key"} {
+		av, aok := a[name]
+		bv, bok := b[name]
+		if aok != bok {
+			return false
+		}
+		if aok && *av != *bv {
+			return false
+		}
+	}
+	return true
+}
+
+// maskAttributes masks the attributes of a resource. extraTags carries
+// block_index/block_key tags identifying which instance of a repeated
+// nested block attributes came from (see maskBlock), so that, e.g., two
+// separate "ingress" block instances that both have a sensitive "password"
+// attribute get distinct secrets instead of colliding on module/resource/
+// attribute alone. nil at the top level, where there's no enclosing block.
+func (s *State) maskAttributes(moduleName, resourceType, resourceName string, attributes map[string]interface{}, schema *configschema.Block, extraTags map[string]*string) error {
 	for attributeName, attributeValue := range attributes {
 		// Check if attribute from the block exists in the schema.
-		if attribute, ok := schema.Attributes[attributeName]; ok && attribute.Sensitive { // Is resource attribute sensitive? Then mask.
+		attribute, inSchema := schema.Attributes[attributeName]
+		sensitive := (inSchema && attribute.Sensitive) || s.attributeNameIsSensitive(resourceType, attributeName)
+		if sensitive { // Is resource attribute sensitive (by schema or by configured pattern)? Then mask.
 			// Tag secret with related state info.
-			tags := make(map[string]*string)
+			tags := make(map[string]*string, len(extraTags)+3)
+			for k, v := range extraTags {
+				tags[k] = v
+			}
 			tags["module"] = &moduleName
 			tags["resource"] = &resourceName
 			a := attributeName
@@ -119,13 +170,7 @@ func (s *State) maskAttributes(moduleName, resourceName string, attributes map[s
 					var secretName string
 					var err error
 					for secretID, secretValue := range s.secretIDs {
-						if _, ok := secretValue.Tags["index"]; ok {
-							if index, ok := tags["index"]; ok && *secretValue.Tags["index"] == *index && *secretValue.Tags["module"] == *tags["module"] && *secretValue.Tags["resource"] == *tags["resource"] && *secretValue.Tags["attribute"] == *tags["attribute"] {
-								secretName = secretID
-								break
-							}
-						}
-						if *secretValue.Tags["module"] == *tags["module"] && *secretValue.Tags["resource"] == *tags["resource"] && *secretValue.Tags["attribute"] == *tags["attribute"] {
+						if secretTagsMatch(secretValue.Tags, tags) {
 							secretName = secretID
 							break
 						}
@@ -144,19 +189,27 @@ func (s *State) maskAttributes(moduleName, resourceName string, attributes map[s
 							if _, ok := s.secretIDs[secretName]; ok {
 								continue // name collision! retrying...
 							}
-							s.secretIDs[secretName] = keyvault.SecretMetadata{Tags: tags}
+							s.secretIDs[secretName] = secretstore.Metadata{Tags: tags}
 							break
 						}
 						if retry >= maxRetries {
 							return nil, fmt.Errorf("error generating random secret name %d times", maxRetries)
 						}
 					}
-					// Set value in keyvault.
-					version, err := s.KeyVault.SetSecret(context.Background(), secretName, v, tags)
+					// Set value in the secret store. During a rotation, always
+					// insert under a new version, even though the plaintext
+					// is unchanged, instead of deduplicating like Put does.
+					var version string
+					var err error
+					if s.rotate {
+						version, err = s.SecretStore.Rotate(context.Background(), secretName, v, tags)
+					} else {
+						version, err = s.SecretStore.Put(context.Background(), secretName, v, tags)
+					}
 					if err != nil {
-						return nil, fmt.Errorf("error inserting secret into key vault: %s", err)
+						return nil, fmt.Errorf("error inserting secret into secret store: %s", err)
 					}
-					// Replace attribute value with a reference/pointer to the secret value in the state key vault.
+					// Replace attribute value with a reference/pointer to the secret value in the secret store.
 					m["type"] = "string"
 					m["id"] = secretName
 					m["version"] = version
@@ -180,8 +233,23 @@ func (s *State) maskAttributes(moduleName, resourceName string, attributes map[s
 					m["value"] = l
 					return m, nil
 				case map[string]interface{}:
+					value := make(map[string]interface{}, len(v))
+					for key, item := range v {
+						mtags := make(map[string]*string, len(tags)+1)
+						for k, v := range tags {
+							mtags[k] = v
+						}
+						k := key
+						mtags["key"] = &k
+						masked, err := f(item, mtags)
+						if err != nil {
+							return nil, err
+						}
+						value[key] = masked
+					}
 					m["type"] = "map[string]interface{}"
-					return nil, fmt.Errorf("map not implemented yet")
+					m["value"] = value
+					return m, nil
 				}
 				return nil, fmt.Errorf("got attribute value of unknown type: %v", attributeValue)
 			}
@@ -190,10 +258,12 @@ func (s *State) maskAttributes(moduleName, resourceName string, attributes map[s
 				return fmt.Errorf("error masking attribute %s with value %v: %s", attributeName, attributeValue, err)
 			}
 		} else {
-			// Nope, then check if it exists in the nested block types.
+			// Nope, then check if it exists in the nested block types. Each
+			// actual child of the block (not the parent's own attributes)
+			// gets masked with the block's own sub-schema.
 			if block, ok := schema.BlockTypes[attributeName]; ok {
-				if err := s.maskAttributes(moduleName, resourceName, attributes, &block.Block); err != nil {
-					return fmt.Errorf("error masking attributes in block type: %s", err)
+				if err := s.maskBlock(moduleName, resourceType, resourceName, attributes[attributeName], block, extraTags); err != nil {
+					return fmt.Errorf("error masking attributes in block type %s: %s", attributeName, err)
 				}
 			}
 		}
@@ -202,79 +272,273 @@ func (s *State) maskAttributes(moduleName, resourceName string, attributes map[s
 	return nil
 }
 
-// unmask unmasks all sensitive attributes in resource states.
+// maskBlock masks every child of a nested block type, dispatching on its
+// Nesting mode to find the actual child attribute maps: a single map for
+// NestingSingle/NestingGroup, a list of maps for NestingList/NestingSet, and
+// a map of maps for NestingMap. A raw value that doesn't match block's
+// nesting mode (e.g. nil for an unset optional block) is left untouched.
+// extraTags carries tags inherited from an enclosing block, if any; for
+// NestingList/NestingSet and NestingMap, each child additionally gets a
+// block_index/block_key tag identifying which instance of the repeated
+// block it came from, so that, e.g., two separate "ingress" block instances
+// that both have a sensitive "password" attribute don't collide onto the
+// same secret.
+func (s *State) maskBlock(moduleName, resourceType, resourceName string, raw interface{}, block *configschema.NestedBlock, extraTags map[string]*string) error {
+	switch block.Nesting {
+	case configschema.NestingSingle, configschema.NestingGroup:
+		if child, ok := raw.(map[string]interface{}); ok {
+			return s.maskAttributes(moduleName, resourceType, resourceName, child, &block.Block, extraTags)
+		}
+	case configschema.NestingList, configschema.NestingSet:
+		children, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, c := range children {
+			child, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			childTags := make(map[string]*string, len(extraTags)+1)
+			for k, v := range extraTags {
+				childTags[k] = v
+			}
+			index := strconv.Itoa(i)
+			childTags["block_index"] = &index
+			if err := s.maskAttributes(moduleName, resourceType, resourceName, child, &block.Block, childTags); err != nil {
+				return err
+			}
+		}
+	case configschema.NestingMap:
+		children, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, c := range children {
+			child, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			childTags := make(map[string]*string, len(extraTags)+1)
+			for k, v := range extraTags {
+				childTags[k] = v
+			}
+			k := key
+			childTags["block_key"] = &k
+			if err := s.maskAttributes(moduleName, resourceType, resourceName, child, &block.Block, childTags); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unmaskConcurrency bounds how many Key Vault secret fetches unmask runs in
+// parallel, so a state with thousands of sensitive attributes doesn't open
+// thousands of simultaneous connections to the vault.
+const unmaskConcurrency = 8
+
+// unmaskJob resolves a single top-level masked attribute back to its
+// plaintext value.
+type unmaskJob struct {
+	attributes map[string]interface{}
+	key        string
+	secret     map[string]interface{}
+}
+
+// unmask unmasks all sensitive attributes in resource states, fetching the
+// underlying secret store values with bounded concurrency and a per-State
+// cache so that repeated Read() calls for unchanged versions don't refetch.
 func (s *State) unmask(rs *[]common.ResourceState) error {
+	var instances []*common.InstanceObjectState
+	var attrsByInstance []map[string]interface{}
+	var jobs []unmaskJob
+
 	for i := range *rs {
 		r := &(*rs)[i]
 		for j := range r.Instances {
 			instance := &r.Instances[j]
 			var attributes map[string]interface{}
-			var err error
 			if err := json.Unmarshal(instance.AttributesRaw, &attributes); err != nil {
 				return fmt.Errorf("error unmarshalling attributes: %s", err)
 			}
 			for key, value := range attributes {
 				if secretAttribute, ok := value.(map[string]interface{}); ok {
-					var f func(map[string]interface{}) (interface{}, bool, error)
-					f = func(secretAttribute map[string]interface{}) (secretAttributeValue interface{}, cont bool, err error) {
-						t, ok := secretAttribute["type"].(string)
-						if !ok {
-							cont = true
-							return
-						}
-						switch t {
-						case "string":
-							id, ok := secretAttribute["id"].(string)
-							if !ok {
-								cont = true
-								return
-							}
-							version, ok := secretAttribute["version"].(string)
-							if !ok {
-								cont = true
-								return
-							}
-							secretAttributeValue, err = s.KeyVault.GetSecret(context.Background(), id, version)
-							if err != nil {
-								err = fmt.Errorf("error getting secret from key vault: %s", err)
-								return
-							}
-							return
-						case "[]interface{}":
-							var l []interface{}
-							for _, v := range secretAttribute["value"].([]interface{}) {
-								secretAttributeValue, cont, err = f(v.(map[string]interface{}))
-								if cont {
-									return
-								}
-								if err != nil {
-									return
-								}
-								l = append(l, secretAttributeValue)
-							}
-							secretAttributeValue = l
-							return
-						case "map[string]interface{}":
-							err = fmt.Errorf("map not implemented yet")
-							return
-						}
-						err = fmt.Errorf("unknown sensitive attribute type: %s", t)
-						return
-					}
-					var cont bool
-					attributes[key], cont, err = f(secretAttribute)
-					if cont {
-						continue
+					jobs = append(jobs, unmaskJob{attributes: attributes, key: key, secret: secretAttribute})
+				}
+			}
+			instances = append(instances, instance)
+			attrsByInstance = append(attrsByInstance, attributes)
+		}
+	}
+
+	values := make([]interface{}, len(jobs))
+	conts := make([]bool, len(jobs))
+	errs := make([]error, len(jobs))
+
+	sem := make(chan struct{}, unmaskConcurrency)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		i, job := i, job
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			values[i], conts[i], errs[i] = s.resolveSecretAttribute(context.Background(), job.secret)
+		}()
+	}
+	wg.Wait()
+
+	for i, job := range jobs {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if conts[i] {
+			continue
+		}
+		job.attributes[job.key] = values[i]
+	}
+
+	for i, instance := range instances {
+		b, err := json.Marshal(&attrsByInstance[i])
+		if err != nil {
+			return fmt.Errorf("error marshalling attributes: %s", err)
+		}
+		instance.AttributesRaw = b
+	}
+	return nil
+}
+
+// resolveSecretAttribute resolves a masked attribute to its plaintext value.
+// cont is true when secretAttribute isn't actually a masked reference (e.g.
+// a regular map-typed attribute that happens to look like one), in which
+// case the caller should leave the original value untouched.
+func (s *State) resolveSecretAttribute(ctx context.Context, secretAttribute map[string]interface{}) (value interface{}, cont bool, err error) {
+	t, ok := secretAttribute["type"].(string)
+	if !ok {
+		return nil, true, nil
+	}
+	switch t {
+	case "string":
+		id, ok := secretAttribute["id"].(string)
+		if !ok {
+			return nil, true, nil
+		}
+		version, ok := secretAttribute["version"].(string)
+		if !ok {
+			return nil, true, nil
+		}
+		value, err = s.getSecretCached(ctx, id, version)
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting secret from secret store: %s", err)
+		}
+		return value, false, nil
+	case "[]interface{}":
+		rawList, _ := secretAttribute["value"].([]interface{})
+		list := make([]interface{}, 0, len(rawList))
+		for _, v := range rawList {
+			item, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			itemValue, itemCont, err := s.resolveSecretAttribute(ctx, item)
+			if err != nil {
+				return nil, false, err
+			}
+			if itemCont {
+				continue
+			}
+			list = append(list, itemValue)
+		}
+		return list, false, nil
+	case "map[string]interface{}":
+		rawMap, _ := secretAttribute["value"].(map[string]interface{})
+		value := make(map[string]interface{}, len(rawMap))
+		for key, v := range rawMap {
+			item, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			itemValue, itemCont, err := s.resolveSecretAttribute(ctx, item)
+			if err != nil {
+				return nil, false, err
+			}
+			if itemCont {
+				continue
+			}
+			value[key] = itemValue
+		}
+		return value, false, nil
+	}
+	return nil, false, fmt.Errorf("unknown sensitive attribute type: %s", t)
+}
+
+// collectSecretIDs walks an instance's decoded attributes (or any value
+// nested inside them, masked or not) and records the secret store id of
+// every masked reference it finds into ids. Used instead of resolving the
+// references to find which secret store entries a resource still needs,
+// without fetching their plaintext.
+func collectSecretIDs(value interface{}, ids map[string]struct{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t, ok := v["type"].(string); ok {
+			switch t {
+			case "string":
+				if id, ok := v["id"].(string); ok {
+					ids[id] = struct{}{}
+				}
+				return
+			case "[]interface{}":
+				if list, ok := v["value"].([]interface{}); ok {
+					for _, item := range list {
+						collectSecretIDs(item, ids)
 					}
-					if err != nil {
-						return err
+				}
+				return
+			case "map[string]interface{}":
+				if m, ok := v["value"].(map[string]interface{}); ok {
+					for _, item := range m {
+						collectSecretIDs(item, ids)
 					}
 				}
+				return
 			}
-			if instance.AttributesRaw, err = json.Marshal(&attributes); err != nil {
-				return fmt.Errorf("error marshalling attributes: %s", err)
-			}
+		}
+		for _, item := range v {
+			collectSecretIDs(item, ids)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectSecretIDs(item, ids)
 		}
 	}
-	return nil
+}
+
+// getSecretCached fetches a secret version from the secret store, memoizing
+// the result so concurrent or repeated lookups of the same id/version don't
+// hit the store again.
+func (s *State) getSecretCached(ctx context.Context, id, version string) (string, error) {
+	cacheKey := id + "#" + version
+
+	s.cacheMu.Lock()
+	if v, ok := s.secretCache[cacheKey]; ok {
+		s.cacheMu.Unlock()
+		return v, nil
+	}
+	s.cacheMu.Unlock()
+
+	value, err := s.SecretStore.Get(ctx, id, version)
+	if err != nil {
+		return "", err
+	}
+
+	s.cacheMu.Lock()
+	if s.secretCache == nil {
+		s.secretCache = make(map[string]string)
+	}
+	s.secretCache[cacheKey] = value
+	s.cacheMu.Unlock()
+
+	return value, nil
 }
@@ -0,0 +1,178 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/secretstore"
+	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestSecretTagsMatch(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	base := map[string]*string{"module": str("root"), "resource": str("r"), "attribute": str("a")}
+	key1 := map[string]*string{"module": str("root"), "resource": str("r"), "attribute": str("a"), "key": str("x")}
+	key2 := map[string]*string{"module": str("root"), "resource": str("r"), "attribute": str("a"), "key": str("y")}
+
+	if secretTagsMatch(key1, key2) {
+		t.Error("two distinct map keys under the same module/resource/attribute must not match")
+	}
+	if !secretTagsMatch(key1, key1) {
+		t.Error("identical tag sets must match")
+	}
+	if secretTagsMatch(base, key1) {
+		t.Error("a plain attribute must not match a map-keyed one even with the same module/resource/attribute")
+	}
+}
+
+// newTestState returns a State backed by an in-memory secret store, with
+// nothing else wired up, sufficient for exercising maskAttributes/maskBlock/
+// resolveSecretAttribute without touching Key Vault or the blob backend.
+func newTestState() *State {
+	return &State{
+		SecretStore: secretstore.NewMemory(),
+		secretIDs:   make(map[string]secretstore.Metadata),
+	}
+}
+
+// secretIDsOf returns the distinct secret store ids referenced by attrs,
+// by walking its masked "string"-typed attribute(s).
+func secretIDsOf(t *testing.T, attrs map[string]interface{}, attributeName string) []string {
+	t.Helper()
+	masked, ok := attrs[attributeName].(map[string]interface{})
+	if !ok {
+		t.Fatalf("attribute %q was not masked: %#v", attributeName, attrs[attributeName])
+	}
+	var ids []string
+	switch masked["type"] {
+	case "string":
+		ids = append(ids, masked["id"].(string))
+	default:
+		t.Fatalf("attribute %q has unexpected masked type %v", attributeName, masked["type"])
+	}
+	return ids
+}
+
+func TestMaskAttributesTopLevelMap(t *testing.T) {
+	s := newTestState()
+	schema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"secrets": {Type: cty.Map(cty.String), Sensitive: true},
+		},
+	}
+	attrs := map[string]interface{}{
+		"secrets": map[string]interface{}{
+			"a": "value-a",
+			"b": "value-b",
+		},
+	}
+
+	if err := s.maskAttributes("root", "test_resource", "r", attrs, schema, nil); err != nil {
+		t.Fatalf("maskAttributes: %s", err)
+	}
+
+	masked, ok := attrs["secrets"].(map[string]interface{})
+	if !ok || masked["type"] != "map[string]interface{}" {
+		t.Fatalf("secrets attribute was not masked as a map: %#v", attrs["secrets"])
+	}
+	value := masked["value"].(map[string]interface{})
+	idA := value["a"].(map[string]interface{})["id"].(string)
+	idB := value["b"].(map[string]interface{})["id"].(string)
+	if idA == idB {
+		t.Fatalf("distinct map keys must not share a secret id, got %q for both", idA)
+	}
+
+	resolved, cont, err := s.resolveSecretAttribute(nil, masked)
+	if err != nil || cont {
+		t.Fatalf("resolveSecretAttribute: cont=%v err=%s", cont, err)
+	}
+	resolvedMap := resolved.(map[string]interface{})
+	if resolvedMap["a"] != "value-a" || resolvedMap["b"] != "value-b" {
+		t.Fatalf("round-trip did not recover original values: %#v", resolvedMap)
+	}
+}
+
+func TestMaskAttributesNestingList(t *testing.T) {
+	s := newTestState()
+	blockSchema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"password": {Type: cty.String, Sensitive: true},
+		},
+	}
+	schema := &configschema.Block{
+		BlockTypes: map[string]*configschema.NestedBlock{
+			"ingress": {Block: *blockSchema, Nesting: configschema.NestingList},
+		},
+	}
+	attrs := map[string]interface{}{
+		"ingress": []interface{}{
+			map[string]interface{}{"password": "first"},
+			map[string]interface{}{"password": "second"},
+		},
+	}
+
+	if err := s.maskAttributes("root", "test_resource", "r", attrs, schema, nil); err != nil {
+		t.Fatalf("maskAttributes: %s", err)
+	}
+
+	children := attrs["ingress"].([]interface{})
+	id0 := secretIDsOf(t, children[0].(map[string]interface{}), "password")[0]
+	id1 := secretIDsOf(t, children[1].(map[string]interface{}), "password")[0]
+	if id0 == id1 {
+		t.Fatalf("distinct ingress block instances must not share a secret id, got %q for both", id0)
+	}
+
+	for i, want := range []string{"first", "second"} {
+		masked := children[i].(map[string]interface{})["password"].(map[string]interface{})
+		got, cont, err := s.resolveSecretAttribute(nil, masked)
+		if err != nil || cont {
+			t.Fatalf("resolveSecretAttribute[%d]: cont=%v err=%s", i, cont, err)
+		}
+		if got != want {
+			t.Errorf("ingress[%d].password round-tripped to %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestMaskAttributesNestingMap(t *testing.T) {
+	s := newTestState()
+	blockSchema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"password": {Type: cty.String, Sensitive: true},
+		},
+	}
+	schema := &configschema.Block{
+		BlockTypes: map[string]*configschema.NestedBlock{
+			"user": {Block: *blockSchema, Nesting: configschema.NestingMap},
+		},
+	}
+	attrs := map[string]interface{}{
+		"user": map[string]interface{}{
+			"alice": map[string]interface{}{"password": "alice-pw"},
+			"bob":   map[string]interface{}{"password": "bob-pw"},
+		},
+	}
+
+	if err := s.maskAttributes("root", "test_resource", "r", attrs, schema, nil); err != nil {
+		t.Fatalf("maskAttributes: %s", err)
+	}
+
+	children := attrs["user"].(map[string]interface{})
+	idAlice := secretIDsOf(t, children["alice"].(map[string]interface{}), "password")[0]
+	idBob := secretIDsOf(t, children["bob"].(map[string]interface{}), "password")[0]
+	if idAlice == idBob {
+		t.Fatalf("distinct user block instances must not share a secret id, got %q for both", idAlice)
+	}
+
+	for key, want := range map[string]string{"alice": "alice-pw", "bob": "bob-pw"} {
+		masked := children[key].(map[string]interface{})["password"].(map[string]interface{})
+		got, cont, err := s.resolveSecretAttribute(nil, masked)
+		if err != nil || cont {
+			t.Fatalf("resolveSecretAttribute[%s]: cont=%v err=%s", key, cont, err)
+		}
+		if got != want {
+			t.Errorf("user[%s].password round-tripped to %q, want %q", key, got, want)
+		}
+	}
+}
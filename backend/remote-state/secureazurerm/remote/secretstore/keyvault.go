@@ -0,0 +1,57 @@
+package secretstore
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/keyvault"
+)
+
+// KeyVaultStore adapts an Azure Key Vault client to the Store interface.
+// It's the default backend. keyvault.KeyVault also exposes operations that
+// have no equivalent in other secret stores, namely access policy
+// reconciliation and key wrap/unwrap for envelope encryption; those
+// continue to go through State.KeyVault directly rather than through Store.
+type KeyVaultStore struct {
+	KeyVault *keyvault.KeyVault
+}
+
+var _ Store = (*KeyVaultStore)(nil)
+
+// NewKeyVaultStore returns a Store backed by kv.
+func NewKeyVaultStore(kv *keyvault.KeyVault) *KeyVaultStore {
+	return &KeyVaultStore{KeyVault: kv}
+}
+
+// Put sets the secret in the key vault. See keyvault.KeyVault.SetSecret.
+func (s *KeyVaultStore) Put(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	return s.KeyVault.SetSecret(ctx, id, value, tags)
+}
+
+// Get fetches the secret version from the key vault.
+func (s *KeyVaultStore) Get(ctx context.Context, id, ref string) (string, error) {
+	return s.KeyVault.GetSecret(ctx, id, ref)
+}
+
+// List returns the metadata of every secret in the key vault.
+func (s *KeyVaultStore) List(ctx context.Context) (map[string]Metadata, error) {
+	secrets, err := s.KeyVault.ListSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]Metadata, len(secrets))
+	for id, m := range secrets {
+		metadata[id] = Metadata{Tags: m.Tags}
+	}
+	return metadata, nil
+}
+
+// Delete deletes the secret from the key vault.
+func (s *KeyVaultStore) Delete(ctx context.Context, id string) error {
+	return s.KeyVault.DeleteSecret(ctx, id)
+}
+
+// Rotate re-inserts the secret under a new key vault secret version. See
+// keyvault.KeyVault.RotateSecret.
+func (s *KeyVaultStore) Rotate(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	return s.KeyVault.RotateSecret(ctx, id, value, tags)
+}
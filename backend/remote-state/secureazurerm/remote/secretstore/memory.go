@@ -0,0 +1,113 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Memory is an in-memory Store, for tests and for running the backend
+// without any external secret-storage dependency. Like Key Vault, every
+// Put/Rotate keeps its own version instead of overwriting the last one, so
+// Get against an older ref still resolves.
+type Memory struct {
+	mu   sync.Mutex
+	next map[string]int               // id -> next version number to assign.
+	data map[string]map[string]string // id -> ref -> value.
+	meta map[string]Metadata          // id -> metadata of its latest Put/Rotate.
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		next: make(map[string]int),
+		data: make(map[string]map[string]string),
+		meta: make(map[string]Metadata),
+	}
+}
+
+// Put inserts value under id, reusing the current version if it already
+// holds the same value, the same deduplication KeyVaultStore's SetSecret does.
+func (m *Memory) Put(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ref := m.latestRef(id); ref != "" && m.data[id][ref] == value {
+		return ref, nil
+	}
+	return m.insert(id, value, tags), nil
+}
+
+// Get fetches the value previously stored under id at ref.
+func (m *Memory) Get(ctx context.Context, id, ref string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions, ok := m.data[id]
+	if !ok {
+		return "", fmt.Errorf("secretstore: no secret named %q", id)
+	}
+	value, ok := versions[ref]
+	if !ok {
+		return "", fmt.Errorf("secretstore: secret %q has no version %q", id, ref)
+	}
+	return value, nil
+}
+
+// List returns the metadata of every secret currently in the store.
+func (m *Memory) List(ctx context.Context) (map[string]Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metadata := make(map[string]Metadata, len(m.meta))
+	for id, md := range m.meta {
+		metadata[id] = md
+	}
+	return metadata, nil
+}
+
+// Delete removes id and every version stored under it.
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, id)
+	delete(m.meta, id)
+	delete(m.next, id)
+	return nil
+}
+
+// Rotate always inserts value under a brand new version, even if it's
+// unchanged, unlike Put.
+func (m *Memory) Rotate(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insert(id, value, tags), nil
+}
+
+// insert assigns id's next version to value and tags. Callers must hold m.mu.
+func (m *Memory) insert(id, value string, tags map[string]*string) string {
+	version := m.next[id]
+	m.next[id] = version + 1
+
+	ref := fmt.Sprintf("v%d", version)
+	if m.data[id] == nil {
+		m.data[id] = make(map[string]string)
+	}
+	m.data[id][ref] = value
+	m.meta[id] = Metadata{Tags: tags}
+	return ref
+}
+
+// latestRef returns id's most recently inserted ref, or "" if id has never
+// been inserted. Callers must hold m.mu.
+func (m *Memory) latestRef(id string) string {
+	version, ok := m.next[id]
+	if !ok || version == 0 {
+		return ""
+	}
+	return fmt.Sprintf("v%d", version-1)
+}
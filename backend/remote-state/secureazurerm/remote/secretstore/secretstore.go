@@ -0,0 +1,44 @@
+// Package secretstore defines the pluggable backend behind remote.State's
+// mask/unmask pipeline. Wherever a resource attribute is sensitive, its
+// plaintext is put into a Store and replaced in the state blob with a
+// reference the Store can later resolve back to the plaintext.
+package secretstore
+
+import "context"
+
+// Metadata is the set of tags a Store records alongside a stored secret.
+// remote.State uses these to find the existing secret backing a given
+// module/resource/attribute instead of inserting a new one on every
+// PersistState.
+type Metadata struct {
+	Tags map[string]*string
+}
+
+// Store puts, fetches, lists, deletes, and rotates the secrets backing
+// masked state attributes, independent of which system actually holds
+// them. Implementations: KeyVaultStore (the default, production use),
+// Memory (tests), and Vault (HashiCorp Vault KV v2, for operators who want
+// the masking mechanic without an Azure Key Vault dependency).
+type Store interface {
+	// Put inserts or updates the secret named id with value and tags,
+	// returning the ref the caller must keep to read it back via Get.
+	// Implementations that dedupe unchanged values (KeyVaultStore does)
+	// may return the existing ref without writing a new version.
+	Put(ctx context.Context, id, value string, tags map[string]*string) (ref string, err error)
+
+	// Get fetches the value stored under id at ref.
+	Get(ctx context.Context, id, ref string) (value string, err error)
+
+	// List returns the metadata of every secret currently in the store,
+	// keyed by id, so PersistState can tell which ones are still
+	// referenced by the state and which are now orphaned.
+	List(ctx context.Context) (map[string]Metadata, error)
+
+	// Delete removes the secret named id entirely, every version included.
+	Delete(ctx context.Context, id string) error
+
+	// Rotate re-inserts id's value under a brand new ref, even when value
+	// is unchanged, unlike Put which may dedupe. Used by State.RotateSecrets
+	// to force a fresh version of every masked attribute.
+	Rotate(ctx context.Context, id, value string, tags map[string]*string) (ref string, err error)
+}
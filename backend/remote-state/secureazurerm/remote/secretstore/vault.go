@@ -0,0 +1,159 @@
+package secretstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// Vault is a Store backed by a HashiCorp Vault KV version 2 secrets engine,
+// for operators who want the state-masking mechanic without an Azure Key
+// Vault dependency. Every Put/Rotate writes a new KV version; the returned
+// ref is that version's number.
+type Vault struct {
+	client    *vaultapi.Client
+	mountPath string // KV v2 engine mount, e.g. "secret", possibly workspace-scoped.
+}
+
+var _ Store = (*Vault)(nil)
+
+// NewVault returns a Store talking to the KV v2 engine mounted at mountPath
+// through client.
+func NewVault(client *vaultapi.Client, mountPath string) *Vault {
+	return &Vault{client: client, mountPath: mountPath}
+}
+
+func (v *Vault) dataPath(id string) string {
+	return fmt.Sprintf("%s/data/%s", v.mountPath, id)
+}
+
+func (v *Vault) metadataPath(id string) string {
+	return fmt.Sprintf("%s/metadata/%s", v.mountPath, id)
+}
+
+// Put writes value as a new KV v2 version of id. KV v2 has no dedup-on-write
+// behavior like Key Vault's SetSecret, so Put and Rotate are identical here.
+func (v *Vault) Put(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	return v.write(ctx, id, value, tags)
+}
+
+// Rotate writes value as a new KV v2 version of id, same as Put.
+func (v *Vault) Rotate(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	return v.write(ctx, id, value, tags)
+}
+
+func (v *Vault) write(ctx context.Context, id, value string, tags map[string]*string) (string, error) {
+	secret, err := v.client.Logical().WriteWithContext(ctx, v.dataPath(id), map[string]interface{}{
+		"data": map[string]interface{}{"value": value},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error writing secret %q to vault: %s", id, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("error writing secret %q to vault: empty response", id)
+	}
+	version, ok := secret.Data["version"].(json.Number)
+	if !ok {
+		return "", fmt.Errorf("error reading version of secret %q from vault response", id)
+	}
+
+	if _, err := v.client.Logical().WriteWithContext(ctx, v.metadataPath(id), map[string]interface{}{
+		"custom_metadata": tagsToCustomMetadata(tags),
+	}); err != nil {
+		return "", fmt.Errorf("error setting metadata for secret %q in vault: %s", id, err)
+	}
+
+	return version.String(), nil
+}
+
+// Get fetches id's value as of version ref.
+func (v *Vault) Get(ctx context.Context, id, ref string) (string, error) {
+	secret, err := v.client.Logical().ReadWithDataWithContext(ctx, v.dataPath(id), map[string][]string{"version": {ref}})
+	if err != nil {
+		return "", fmt.Errorf("error reading secret %q version %s from vault: %s", id, ref, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("secret %q version %s not found in vault", id, ref)
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("secret %q version %s has an unexpected shape in vault", id, ref)
+	}
+	value, ok := data["value"].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %q version %s has no string \"value\" field in vault", id, ref)
+	}
+	return value, nil
+}
+
+// List returns the tags of every secret under the mount, read back from
+// each secret's KV v2 custom_metadata.
+func (v *Vault) List(ctx context.Context) (map[string]Metadata, error) {
+	listing, err := v.client.Logical().ListWithContext(ctx, v.metadataPath(""))
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets from vault: %s", err)
+	}
+	if listing == nil || listing.Data == nil {
+		return map[string]Metadata{}, nil
+	}
+	rawKeys, _ := listing.Data["keys"].([]interface{})
+
+	metadata := make(map[string]Metadata, len(rawKeys))
+	for _, rawKey := range rawKeys {
+		id, ok := rawKey.(string)
+		if !ok {
+			continue
+		}
+		meta, err := v.client.Logical().ReadWithContext(ctx, v.metadataPath(id))
+		if err != nil {
+			return nil, fmt.Errorf("error reading metadata for secret %q from vault: %s", id, err)
+		}
+		var tags map[string]*string
+		if meta != nil && meta.Data != nil {
+			if raw, ok := meta.Data["custom_metadata"].(map[string]interface{}); ok {
+				tags = customMetadataToTags(raw)
+			}
+		}
+		metadata[id] = Metadata{Tags: tags}
+	}
+	return metadata, nil
+}
+
+// Delete removes id's metadata and every version stored under it. Deleting
+// only the data path would instead soft-delete the latest version and
+// leave the others (and the custom_metadata) behind.
+func (v *Vault) Delete(ctx context.Context, id string) error {
+	if _, err := v.client.Logical().DeleteWithContext(ctx, v.metadataPath(id)); err != nil {
+		return fmt.Errorf("error deleting secret %q from vault: %s", id, err)
+	}
+	return nil
+}
+
+// tagsToCustomMetadata converts the mask pipeline's tags into the
+// map[string]interface{} KV v2's custom_metadata write expects.
+func tagsToCustomMetadata(tags map[string]*string) map[string]interface{} {
+	out := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			out[k] = *v
+		}
+	}
+	return out
+}
+
+// customMetadataToTags converts KV v2's custom_metadata read response back
+// into the mask pipeline's tags.
+func customMetadataToTags(raw map[string]interface{}) map[string]*string {
+	tags := make(map[string]*string, len(raw))
+	for k, v := range raw {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		s := s
+		tags[k] = &s
+	}
+	return tags
+}
@@ -0,0 +1,79 @@
+package secretstore
+
+import (
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// VaultAuthConfig carries whichever fields AuthMethod needs to obtain a
+// Vault token: Token for "token", RoleID/SecretID for "approle", RoleID
+// (naming the Vault role) for "azure".
+type VaultAuthConfig struct {
+	AuthMethod string
+	Token      string
+	RoleID     string
+	SecretID   string
+}
+
+// Authenticate obtains a Vault token per cfg.AuthMethod and sets it on
+// client. "token" uses cfg.Token as-is; "approle" exchanges RoleID/SecretID
+// via auth/approle/login; "azure" logs in to auth/azure/login with the
+// backend's own managed identity JWT, under the Vault role named by RoleID.
+func Authenticate(client *vaultapi.Client, cfg VaultAuthConfig) error {
+	switch cfg.AuthMethod {
+	case "", "token":
+		client.SetToken(cfg.Token)
+		return nil
+	case "approle":
+		secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+			"role_id":   cfg.RoleID,
+			"secret_id": cfg.SecretID,
+		})
+		if err != nil {
+			return fmt.Errorf("error logging in to vault via approle: %s", err)
+		}
+		if secret == nil || secret.Auth == nil {
+			return fmt.Errorf("error logging in to vault via approle: empty response")
+		}
+		client.SetToken(secret.Auth.ClientToken)
+		return nil
+	case "azure":
+		jwt, err := azureManagedIdentityJWT()
+		if err != nil {
+			return fmt.Errorf("error obtaining managed identity token for vault azure auth: %s", err)
+		}
+		secret, err := client.Logical().Write("auth/azure/login", map[string]interface{}{
+			"role": cfg.RoleID,
+			"jwt":  jwt,
+		})
+		if err != nil {
+			return fmt.Errorf("error logging in to vault via azure auth: %s", err)
+		}
+		if secret == nil || secret.Auth == nil {
+			return fmt.Errorf("error logging in to vault via azure auth: empty response")
+		}
+		client.SetToken(secret.Auth.ClientToken)
+		return nil
+	default:
+		return fmt.Errorf("unknown vault auth_method %q", cfg.AuthMethod)
+	}
+}
+
+// azureManagedIdentityJWT fetches a token for the Resource Manager audience
+// from the backend's own managed identity via IMDS, which is the JWT Vault's
+// azure auth method expects to validate a login against Azure AD.
+func azureManagedIdentityJWT() (string, error) {
+	msiConfig := auth.NewMSIConfig()
+	msiConfig.Resource = azure.PublicCloud.ResourceManagerEndpoint
+	spt, err := msiConfig.ServicePrincipalToken()
+	if err != nil {
+		return "", fmt.Errorf("error creating managed identity token: %s", err)
+	}
+	if err := spt.RefreshWithContext(spt.Context()); err != nil {
+		return "", fmt.Errorf("error refreshing managed identity token: %s", err)
+	}
+	return spt.OAuthToken(), nil
+}
@@ -2,9 +2,13 @@ package remote
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/hashicorp/terraform/addrs"
@@ -12,6 +16,7 @@ import (
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/properties"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account/blob"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/secretstore"
 	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/states"
 	"github.com/hashicorp/terraform/states/statefile"
@@ -24,10 +29,27 @@ type State struct {
 	mu sync.Mutex
 
 	Blob     *blob.Blob         // client to communicate with the state blob storage.
-	KeyVault *keyvault.KeyVault // client to communicate with the state key vault.
+	KeyVault *keyvault.KeyVault // client to communicate with the state key vault, for access policy reconciliation and envelope key wrap/unwrap (see envelope.go). Always Key Vault, regardless of SecretStore.
+
+	// SecretStore holds the masked value of every sensitive state
+	// attribute, referenced by mask/unmask (see mask.go). Defaults to a
+	// secretstore.KeyVaultStore wrapping KeyVault, but can be swapped for
+	// another backend (e.g. HashiCorp Vault) via configuration.
+	SecretStore secretstore.Store
 
 	Props *properties.Properties
 
+	// Output, when set, receives a colorized diff summary every time
+	// PersistState changes the state key vault's access policies, so an
+	// operator can see policy churn per apply. Nil disables logging (e.g.
+	// when State is used outside the CLI's own apply/plan/refresh flow).
+	Output func(string)
+
+	// SensitiveAttributePatterns additionally masks any attribute whose name,
+	// or "<resource type>.<attribute name>", matches one of these regexps,
+	// on top of whatever the provider schema already marks Sensitive.
+	SensitiveAttributePatterns []*regexp.Regexp
+
 	lineage      string
 	serial       uint64
 	disableLocks bool
@@ -35,7 +57,34 @@ type State struct {
 	state, // current in-memory state.
 	readState *states.State // state read from the blob
 
-	secretIDs map[string]keyvault.SecretMetadata
+	secretIDs map[string]secretstore.Metadata
+
+	// secretCache memoizes SecretStore fetches by "<id>#<version>" so that
+	// repeated Read()s of the same state don't re-fetch unchanged values.
+	cacheMu     sync.Mutex
+	secretCache map[string]string
+
+	// dekCache memoizes unwrapped data encryption keys by "<kid>#<wrapped
+	// dek>" so that repeated RefreshState calls against an unchanged,
+	// envelope-encrypted blob don't re-unwrap the DEK via Key Vault. See
+	// Props.EncryptionKeyName and envelope.go.
+	dekCacheMu sync.Mutex
+	dekCache   map[string][]byte
+
+	// rotate, when set, makes mask() re-insert every sensitive attribute's
+	// value under a brand new Key Vault secret version instead of reusing
+	// the existing one. Set for the duration of RotateSecrets.
+	rotate bool
+
+	// chunks caches content-addressed resource chunk blobs by hash (see
+	// common.Manifest and blob.Blob.GetChunk/PutChunk), lazily created on
+	// first use.
+	chunks *chunkCache
+
+	// SnapshotRetention, when set, makes PersistState prune the blob's
+	// snapshots down to what the policy keeps after every successful write.
+	// Nil disables pruning; snapshots then accumulate forever.
+	SnapshotRetention *blob.RetentionPolicy
 }
 
 // State reads the state from the memory.
@@ -78,11 +127,50 @@ func (s *State) RefreshState() error {
 		return nil
 	}
 
-	// Unmask remote state.
+	// If the blob holds an envelope-encrypted payload, decrypt it first; a
+	// payload written before encryption was enabled just doesn't carry the
+	// wrapping metadata decryptPayload needs, so it's used as-is.
+	data := payload.Data
+	if metadata, err := s.Blob.Metadata(); err == nil {
+		if plaintext, err := s.decryptPayload(payload.Data, metadata); err == nil {
+			data = plaintext
+		}
+	}
+
+	// Decode the blob body, either as a manifest whose resources live in
+	// separate chunk blobs (see common.Manifest), or, for a blob written
+	// before incremental persistence existed, as a monolithic SecureState
+	// with every resource inline.
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err = json.Unmarshal(data, &version); err != nil {
+		return fmt.Errorf("error unmarshalling state: %s", err)
+	}
+
 	var secureState common.SecureState
-	if err = json.Unmarshal(payload.Data, &secureState); err != nil {
+	if version.Version == common.ManifestVersion {
+		var manifest common.Manifest
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("error unmarshalling manifest: %s", err)
+		}
+		resources, err := s.fetchChunks(manifest.Entries)
+		if err != nil {
+			return fmt.Errorf("error fetching state chunks: %s", err)
+		}
+		secureState = common.SecureState{
+			Version:          manifest.Version,
+			TerraformVersion: manifest.TerraformVersion,
+			Serial:           manifest.Serial,
+			Lineage:          manifest.Lineage,
+			RootOutputs:      manifest.RootOutputs,
+			Resources:        resources,
+		}
+	} else if err = json.Unmarshal(data, &secureState); err != nil {
 		return fmt.Errorf("error unmarshalling state: %s", err)
 	}
+
+	// Unmask remote state.
 	if err = s.unmask(secureState.Resources); err != nil {
 		return fmt.Errorf("error unmasking state: %s", err)
 	}
@@ -294,6 +382,210 @@ func appendInstanceObjectState(rs *states.Resource, is *states.ResourceInstance,
 	}), nil
 }
 
+// chunkFetchConcurrency bounds how many chunk blobs RefreshState fetches at
+// once, the same way unmaskConcurrency bounds concurrent secret fetches in
+// mask.go: fast enough for a state with many resources, without opening an
+// unbounded number of connections to the storage account.
+const chunkFetchConcurrency = 8
+
+// hashResource returns the content hash a manifest entry uses to reference
+// resource's chunk blob. Two resources with identical masked state hash the
+// same, so PersistState can skip re-uploading an unchanged resource.
+func hashResource(resource common.ResourceState) (hash string, data []byte, err error) {
+	data, err = json.Marshal(&resource)
+	if err != nil {
+		return "", nil, fmt.Errorf("error marshalling resource %s.%s: %s", resource.Type, resource.Name, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data, nil
+}
+
+// fetchChunks resolves entries' chunk blobs back into ResourceStates,
+// bounded to chunkFetchConcurrency concurrent blob GETs and backed by
+// s.chunks so a resource whose chunk was already fetched this RefreshState
+// (or a previous one) isn't fetched again.
+func (s *State) fetchChunks(entries []common.ManifestEntry) ([]common.ResourceState, error) {
+	if s.chunks == nil {
+		s.chunks = newChunkCache()
+	}
+
+	resources := make([]common.ResourceState, len(entries))
+	errs := make([]error, len(entries))
+
+	sem := make(chan struct{}, chunkFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		i, entry := i, entry
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resources[i], errs[i] = s.fetchChunk(entry)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resources, nil
+}
+
+// fetchChunk resolves a single manifest entry's chunk blob into a
+// ResourceState, via s.chunks.
+func (s *State) fetchChunk(entry common.ManifestEntry) (common.ResourceState, error) {
+	data, ok := s.chunks.get(entry.Hash)
+	if !ok {
+		fetched, exists, err := s.Blob.GetChunk(entry.Hash)
+		if err != nil {
+			return common.ResourceState{}, fmt.Errorf("error fetching chunk %s for resource %s.%s: %s", entry.Hash, entry.Type, entry.Name, err)
+		}
+		if !exists {
+			return common.ResourceState{}, fmt.Errorf("manifest references chunk %s for resource %s.%s, which doesn't exist", entry.Hash, entry.Type, entry.Name)
+		}
+		data = fetched
+		s.chunks.put(entry.Hash, data)
+	}
+
+	var resource common.ResourceState
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return common.ResourceState{}, fmt.Errorf("error unmarshalling chunk %s: %s", entry.Hash, err)
+	}
+	return resource, nil
+}
+
+// snapshotSecretIDs returns the secret store id of every masked attribute
+// referenced by any of the blob's existing snapshots, decoding each one the
+// same way RefreshState decodes the head blob (manifest-plus-chunks, or,
+// for a snapshot taken before incremental persistence existed, an inline
+// SecureState) and decrypting it first if it's envelope-encrypted.
+// PersistState consults this before deleting an orphaned secret so a secret
+// still needed to restore an older snapshot byte-for-byte isn't deleted out
+// from under it. A snapshot that's since been pruned simply stops
+// protecting its secrets on the next apply; this does not itself prune
+// anything. This reads every snapshot's resources on every apply, which is
+// fine at the scale SnapshotRetention is meant for but does scale linearly
+// with snapshot count.
+func (s *State) snapshotSecretIDs() (map[string]struct{}, error) {
+	snapshots, err := s.Blob.ListSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %s", err)
+	}
+
+	ids := make(map[string]struct{})
+	for _, snap := range snapshots {
+		data, metadata, err := s.Blob.GetSnapshot(snap.SnapshotTime)
+		if err != nil {
+			return nil, fmt.Errorf("error reading snapshot taken at %s: %s", snap.SnapshotTime, err)
+		}
+		if plaintext, err := s.decryptPayload(data, metadata); err == nil {
+			data = plaintext
+		}
+
+		var version struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(data, &version); err != nil {
+			return nil, fmt.Errorf("error unmarshalling snapshot taken at %s: %s", snap.SnapshotTime, err)
+		}
+
+		var resources []common.ResourceState
+		if version.Version == common.ManifestVersion {
+			var manifest common.Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return nil, fmt.Errorf("error unmarshalling manifest of snapshot taken at %s: %s", snap.SnapshotTime, err)
+			}
+			if resources, err = s.fetchChunks(manifest.Entries); err != nil {
+				return nil, fmt.Errorf("error fetching chunks of snapshot taken at %s: %s", snap.SnapshotTime, err)
+			}
+		} else {
+			var secureState common.SecureState
+			if err := json.Unmarshal(data, &secureState); err != nil {
+				return nil, fmt.Errorf("error unmarshalling snapshot taken at %s: %s", snap.SnapshotTime, err)
+			}
+			resources = secureState.Resources
+		}
+
+		for _, resource := range resources {
+			for _, instance := range resource.Instances {
+				var attributes map[string]interface{}
+				if err := json.Unmarshal(instance.AttributesRaw, &attributes); err != nil {
+					return nil, fmt.Errorf("error unmarshalling attributes of snapshot taken at %s: %s", snap.SnapshotTime, err)
+				}
+				collectSecretIDs(attributes, ids)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// accessPolicyGrants resolves policies to the access grants each entry's
+// resource currently represents, walking file.State by address. A resource
+// that doesn't exist in state yet (not applied, or already destroyed) is
+// simply skipped, rather than erroring.
+func accessPolicyGrants(file *statefile.File, policies []properties.AccessPolicyConfig) ([]keyvault.AccessPolicyGrant, error) {
+	var grants []keyvault.AccessPolicyGrant
+	for _, ap := range policies {
+		instAddr, diags := addrs.ParseAbsResourceInstanceStr(ap.ResourceAddress)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("error parsing access_policy resource_address %q: %s", ap.ResourceAddress, diags.Err())
+		}
+
+		resource := file.State.Resource(instAddr.ContainingResource())
+		if resource == nil {
+			continue
+		}
+		profile := keyvault.ProfileFromPermissions(ap.Permissions)
+		for _, instance := range resource.Instances {
+			if instance.Current == nil {
+				continue
+			}
+			var attributes map[string]interface{}
+			if err := json.Unmarshal(instance.Current.AttrsJSON, &attributes); err != nil {
+				return nil, fmt.Errorf("error unmarshalling attributes for %s: %s", ap.ResourceAddress, err)
+			}
+			identities, ok := attributes["identity"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, raw := range identities {
+				identity, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				principalID, _ := identity["principal_id"].(string)
+				if principalID == "" {
+					continue
+				}
+				tenantID, _ := identity["tenant_id"].(string)
+				grants = append(grants, keyvault.AccessPolicyGrant{
+					Identity: keyvault.ManagedIdentity{PrincipalID: principalID, TenantID: tenantID},
+					Profile:  profile,
+				})
+			}
+		}
+	}
+	return grants, nil
+}
+
+// accessPolicyDiffMessage formats an access policy reconciliation's diff as
+// a colorized summary, in the same style as the rest of the backend's CLI
+// output (see Backend.ColorOutput).
+func accessPolicyDiffMessage(added, removed []string) string {
+	var b strings.Builder
+	b.WriteString("[reset][bold]Key Vault access policy changes:[reset]\n")
+	for _, objectID := range added {
+		b.WriteString(fmt.Sprintf("  [green]+[reset] %s\n", objectID))
+	}
+	for _, objectID := range removed {
+		b.WriteString(fmt.Sprintf("  [red]-[reset] %s\n", objectID))
+	}
+	return b.String()
+}
+
 // PersistState saves the in-memory state to the blob.
 func (s *State) PersistState() error {
 	// Lock, harr!
@@ -304,21 +596,9 @@ func (s *State) PersistState() error {
 		return errors.New("state is empty")
 	}
 
-	// Get state key vault's access policies.
-	accessPolicies, err := s.KeyVault.GetAccessPolicies(context.Background())
-	if err != nil {
-		return fmt.Errorf("error getting the state key vault's access policies: %s", err)
-	}
-	for i, policy := range accessPolicies {
-		// Remove itself from the access policy list.
-		if *policy.ObjectID == s.Props.ObjectID {
-			accessPolicies = append(accessPolicies[:i], accessPolicies[i+1:]...)
-			break
-		}
-	}
-
-	// List and save all secrets from the keyvault.
-	s.secretIDs, err = s.KeyVault.ListSecrets(context.Background())
+	// List and save all secrets from the secret store.
+	var err error
+	s.secretIDs, err = s.SecretStore.List(context.Background())
 	if err != nil {
 		return fmt.Errorf("error listing secrets: %s", err)
 	}
@@ -414,64 +694,38 @@ func (s *State) PersistState() error {
 			}
 
 			// Mask the resource state.
-			if err := s.mask(state.Resources); err != nil {
+			if err := s.mask(resourceState); err != nil {
 				return fmt.Errorf("error masking module: %s", err)
 			}
 		}
-		// Compare the existing access policies with current resources. Delete those that does not exist anymore.
-		for _, accessPolicy := range accessPolicies {
-			for _, resource := range state.Resources {
-				for _, instance := range resource.Instances {
-					var attributes map[string]interface{}
-					if err := json.Unmarshal(instance.AttributesRaw, &attributes); err != nil {
-						return fmt.Errorf("error unmarshalling attributes: %s", err)
-					}
-					identities, ok := attributes["identity"].(map[string]interface{})
-					if !ok {
-						continue
-					}
-					for _, identity := range identities {
-						id := identity.(map[string]interface{})
-						if *accessPolicy.ObjectID == id["principal_id"].(string) {
-							goto end
-						}
-					}
-				}
-			}
-			if err = s.KeyVault.RemoveIDFromAccessPolicies(context.Background(), *accessPolicy.TenantID, *accessPolicy.ObjectID); err != nil {
-				return fmt.Errorf("error removing managed ID from access policies: %s", err)
-			}
-		end:
-		}
+	}
 
-		/*
-			// Give resources access to the state as described in access_policies in the configuration.
-			for _, accessPolicy := range s.Props.AccessPolicies {
-				resource, ok := mod["resources"].(map[string]interface{})[strings.Join(accessPolicyDotSplitted[len(path):], ".")]
-				if !ok {
-					continue // could not find resource, perhaps due to being destroyed.
-				}
-				attributes := resource.(map[string]interface{})["primary"].(map[string]interface{})["attributes"].(map[string]interface{})
-				value, ok := attributes["identity.#"]
-				if !ok {
-					return fmt.Errorf("access_policies contains a resource with no managed identity: %s", err)
-				}
-				length, err := strconv.Atoi(value.(string))
-				if err != nil {
-					return fmt.Errorf("error converting identity.# to integer: %s", err)
-				}
-				for i := 0; i < length; i++ {
-					managedIdentity := keyvault.ManagedIdentity{
-						PrincipalID: attributes[fmt.Sprintf("identity.%d.principal_id", i)].(string),
-						TenantID:    attributes[fmt.Sprintf("identity.%d.tenant_id", i)].(string),
-					}
-					s.KeyVault.AddIDToAccessPolicies(context.Background(), &managedIdentity)
-				}
-			}
-		*/
+	// Reconcile the state key vault's access policies against the
+	// access_policy {} blocks in configuration: resolve each entry's current
+	// principal_id/tenant_id by walking file.State by address (so a
+	// destroyed or not-yet-applied resource is simply skipped, instead of
+	// scanning every resource's raw "identity" attribute), then replace the
+	// vault's access in a single batched call. Anything granted previously
+	// but no longer declared (or whose resource is gone) is dropped.
+	grants, err := accessPolicyGrants(file, s.Props.AccessPolicies)
+	if err != nil {
+		return fmt.Errorf("error resolving access_policy grants: %s", err)
+	}
+	operator := keyvault.ManagedIdentity{PrincipalID: s.Props.ObjectID, TenantID: s.Props.TenantID}
+	added, removed, err := s.KeyVault.SetAccessPolicies(context.Background(), operator, grants)
+	if err != nil {
+		return fmt.Errorf("error reconciling key vault access policies: %s", err)
+	}
+	if s.Output != nil && (len(added) > 0 || len(removed) > 0) {
+		s.Output(accessPolicyDiffMessage(added, removed))
 	}
 
-	// Delete the resource's attributes that does not exists anymore in the key vault.
+	// Delete secrets that no longer back any attribute of the current state
+	// AND aren't still needed to restore one of the blob's existing
+	// snapshots byte-for-byte (see snapshotSecretIDs): a resource whose
+	// secret-backed attribute was removed from the current state, but is
+	// still referenced by a snapshot taken before that happened, keeps its
+	// secret around until that snapshot itself is pruned.
 	resourceAttributeSecretIDs := make(map[string]struct{})
 	for _, resource := range state.Resources {
 		for _, instance := range resource.Instances {
@@ -479,40 +733,128 @@ func (s *State) PersistState() error {
 			if err := json.Unmarshal(instance.AttributesRaw, &attributes); err != nil {
 				return fmt.Errorf("error unmarshalling attributes: %s", err)
 			}
-			for _, attribute := range attributes {
-				if object, ok := attribute.(secretAttribute); ok {
-					resourceAttributeSecretIDs[object.ID] = struct{}{}
-				}
-			}
+			collectSecretIDs(attributes, resourceAttributeSecretIDs)
 		}
 	}
+	protectedSecretIDs, err := s.snapshotSecretIDs()
+	if err != nil {
+		return fmt.Errorf("error resolving secrets referenced by existing snapshots: %s", err)
+	}
+	for id := range protectedSecretIDs {
+		resourceAttributeSecretIDs[id] = struct{}{}
+	}
 	for secretID := range s.secretIDs {
 		if _, ok := resourceAttributeSecretIDs[secretID]; !ok {
-			if err := s.KeyVault.DeleteSecret(context.Background(), secretID); err != nil {
+			if err := s.SecretStore.Delete(context.Background(), secretID); err != nil {
 				return fmt.Errorf("error deleting secret %s: %s", secretID, err)
 			}
 			delete(s.secretIDs, secretID)
 		}
 	}
 
-	// Marshal state map to JSON.
-	b, err := json.MarshalIndent(&state, "", "  ")
+	// Split resources into content-addressed chunks, uploading only those
+	// whose hash changed since the last persist (PutChunk is a no-op for a
+	// hash that already exists), and reference them from a manifest instead
+	// of embedding every resource's state inline.
+	manifest := &common.Manifest{
+		Version:          common.ManifestVersion,
+		TerraformVersion: state.TerraformVersion,
+		Serial:           state.Serial,
+		Lineage:          state.Lineage,
+		RootOutputs:      state.RootOutputs,
+		Entries:          make([]common.ManifestEntry, len(state.Resources)),
+	}
+	for i, resource := range state.Resources {
+		hash, data, err := hashResource(resource)
+		if err != nil {
+			return fmt.Errorf("error hashing resource %s.%s: %s", resource.Type, resource.Name, err)
+		}
+		if err := s.Blob.PutChunk(hash, data); err != nil {
+			return fmt.Errorf("error writing chunk for resource %s.%s: %s", resource.Type, resource.Name, err)
+		}
+		manifest.Entries[i] = common.ManifestEntry{
+			Module: resource.Module,
+			Mode:   resource.Mode,
+			Type:   resource.Type,
+			Name:   resource.Name,
+			Hash:   hash,
+		}
+	}
+
+	// Marshal the manifest to JSON.
+	b, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error marshalling map: %s", err)
+		return fmt.Errorf("error marshalling manifest: %s", err)
 	}
 	b = append(b, '\n')
 
-	// Put it into the blob.
-	if err := s.Blob.Put(b); err != nil {
+	// Envelope-encrypt the marshaled manifest if a state encryption key is
+	// configured, carrying the wrapped DEK alongside it in blob metadata
+	// rather than in the body, so a fresh DEK per write doesn't also mean
+	// re-deriving a body framing format on every read.
+	if s.Props.EncryptionKeyName != "" || s.Props.EncryptionKeyBase64 != "" {
+		ciphertext, metadata, err := s.encryptPayload(b)
+		if err != nil {
+			return fmt.Errorf("error encrypting state: %s", err)
+		}
+		// Already lease-protected like every other write path; the extra
+		// If-Match check below is for the unencrypted path only, since
+		// that's what PutIfMatch's ETag comparison is meaningful against.
+		if err := s.Blob.PutWithMetadata(ciphertext, metadata); err != nil {
+			return fmt.Errorf("error leasing and putting buffer: %s", err)
+		}
+	} else if err := s.Blob.PutIfMatch(b, s.Blob.ETag()); err != nil {
 		return fmt.Errorf("error leasing and putting buffer: %s", err)
 	}
 
+	// Prune old snapshots down to what the retention policy keeps, now that
+	// this write created a fresh one.
+	if s.SnapshotRetention != nil {
+		if err := s.Blob.PruneSnapshots(*s.SnapshotRetention); err != nil {
+			return fmt.Errorf("error pruning snapshots: %s", err)
+		}
+	}
+
 	// Set the persisted state as our new main reference state.
 	s.readState = s.state.DeepCopy()
 
 	return nil
 }
 
+// RotateSecrets re-encrypts every sensitive attribute currently in the
+// in-memory state under a new Key Vault secret version and rewrites the
+// state blob with the result, atomically, under the lease the caller
+// already holds (see Lock). The plaintext values themselves don't change;
+// only the secret version backing each masked attribute does.
+func (s *State) RotateSecrets() error {
+	if s.state == nil {
+		return errors.New("state is empty")
+	}
+
+	s.rotate = true
+	defer func() { s.rotate = false }()
+
+	return s.PersistState()
+}
+
+// ETag returns the ETag of the state blob as of the last RefreshState or
+// PersistState call.
+func (s *State) ETag() string {
+	return s.Blob.ETag()
+}
+
+// PeekLockInfo reports the lock info currently recorded on the state blob,
+// if any, without acquiring the lease.
+func (s *State) PeekLockInfo() (*state.LockInfo, error) {
+	return s.Blob.PeekLockInfo()
+}
+
+// RenewLease renews the Azure blob lease backing this state's lock,
+// extending it by another lease period. See blob.Blob.RenewLease.
+func (s *State) RenewLease() error {
+	return s.Blob.RenewLease()
+}
+
 // Lock locks the state.
 func (s *State) Lock(info *state.LockInfo) (string, error) {
 	if s.disableLocks {
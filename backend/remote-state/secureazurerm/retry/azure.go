@@ -0,0 +1,88 @@
+package retry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// transientStatusCodes are HTTP statuses, returned by either the
+// management (autorest) or storage data-plane SDK, that represent a
+// transient condition worth retrying. 401/403/404 are deliberately absent:
+// those are configuration/auth errors, not flakiness, and retrying them
+// just delays the real failure.
+var transientStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true, // 408
+	http.StatusTooManyRequests:     true, // 429
+	http.StatusInternalServerError: true, // 500
+	http.StatusBadGateway:          true, // 502
+	http.StatusServiceUnavailable:  true, // 503
+	http.StatusGatewayTimeout:      true, // 504
+}
+
+// transientStorageCodes are storage.AzureStorageServiceError codes that
+// represent a transient condition worth retrying.
+var transientStorageCodes = map[string]bool{
+	"ServerBusy":        true,
+	"InternalError":     true,
+	"OperationTimedOut": true,
+}
+
+// AzureTransient classifies errors returned by the ARM/autorest-based
+// management clients and the storage data-plane client, retrying on the
+// status codes and storage error codes above and honoring a Retry-After
+// header when Azure sent one.
+func AzureTransient(err error) (retryable bool, retryAfter time.Duration) {
+	switch e := err.(type) {
+	case autorest.DetailedError:
+		code, _ := e.StatusCode.(int)
+		if !transientStatusCodes[code] {
+			return false, 0
+		}
+		if e.Response != nil {
+			return true, retryAfterHeader(e.Response)
+		}
+		return true, 0
+	case storage.AzureStorageServiceError:
+		if !transientStatusCodes[e.StatusCode] && !transientStorageCodes[e.Code] {
+			return false, 0
+		}
+		return true, 0
+	default:
+		return false, 0
+	}
+}
+
+// LeaseConflict classifies the 409 LeaseAlreadyPresent error
+// blob.Blob.Lock gets back when another holder already has the blob
+// leased. It's used with a much shorter budget than AzureTransient so a
+// real, held lock still fails Lock fast instead of retrying for minutes.
+func LeaseConflict(err error) (retryable bool, retryAfter time.Duration) {
+	if e, ok := err.(storage.AzureStorageServiceError); ok {
+		return e.StatusCode == http.StatusConflict && e.Code == "LeaseAlreadyPresent", 0
+	}
+	return false, 0
+}
+
+// retryAfterHeader parses the Retry-After header of resp, which Azure
+// sends as either a number of seconds or an HTTP-date. It returns 0 (let
+// the policy's own backoff schedule apply) if the header is absent or
+// unparseable.
+func retryAfterHeader(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+// Package retry provides a shared exponential-backoff loop for the
+// transient failures Azure's management and data-plane SDKs surface during
+// normal operation (throttling, momentary unavailability, lease
+// contention), so that a flaky SDK call doesn't fail an entire plan/apply.
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy configures the backoff loop Do runs an operation under. The delay
+// before each retry starts at BaseDelay and doubles every attempt, capped
+// at MaxInterval, and jittered by up to +/-50% so concurrent callers don't
+// all wake up and retry at once.
+type Policy struct {
+	// MaxRetries caps the number of attempts after the first. 0 means
+	// retry for as long as MaxElapsed allows.
+	MaxRetries int
+	// MaxElapsed caps the total wall-clock time spent retrying, measured
+	// from the first attempt. A retry whose delay would push past this is
+	// not attempted; the last error is returned instead. 0 means no cap.
+	MaxElapsed time.Duration
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxInterval caps how large the doubling delay is allowed to grow. 0
+	// means uncapped.
+	MaxInterval time.Duration
+}
+
+// DefaultPolicy is used wherever the backend schema leaves the retry
+// fields unset: base 1s, doubling, capped at 30s, for up to 5 minutes
+// total.
+var DefaultPolicy = Policy{
+	BaseDelay:   time.Second,
+	MaxInterval: 30 * time.Second,
+	MaxElapsed:  5 * time.Minute,
+}
+
+// Classifier decides whether err is worth retrying and, if so, how long to
+// wait before the next attempt. A zero retryAfter means "use the policy's
+// own backoff schedule"; a positive one (e.g. from a Retry-After header)
+// overrides it.
+type Classifier func(err error) (retryable bool, retryAfter time.Duration)
+
+// Do calls op, retrying it under policy's backoff schedule for as long as
+// classify says the error is retryable. It returns nil as soon as op
+// succeeds, and the last error once classify says to stop, MaxRetries is
+// exhausted, or MaxElapsed has passed.
+func Do(policy Policy, classify Classifier, op func() error) error {
+	start := time.Now()
+	delay := policy.BaseDelay
+	if delay <= 0 {
+		delay = DefaultPolicy.BaseDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		retryable, retryAfter := classify(err)
+		if !retryable {
+			return err
+		}
+		if policy.MaxRetries > 0 && attempt >= policy.MaxRetries {
+			return err
+		}
+
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed {
+			return err
+		}
+
+		time.Sleep(jitter(wait))
+
+		delay *= 2
+		if policy.MaxInterval > 0 && delay > policy.MaxInterval {
+			delay = policy.MaxInterval
+		}
+	}
+}
+
+// jitter returns d randomized by +/-50%.
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.5 + rand.Float64()))
+}
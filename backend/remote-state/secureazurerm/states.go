@@ -3,11 +3,18 @@ package secureazurerm
 import (
 	"context"
 	"fmt"
+	"os"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
 
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/account/blob"
 	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/keyvault"
+	"github.com/hashicorp/terraform/backend/remote-state/secureazurerm/remote/secretstore"
 	"github.com/hashicorp/terraform/state"
+	tfremote "github.com/hashicorp/terraform/state/remote"
 )
 
 // Workspaces returns the name of all blobs that stores the state file.
@@ -41,7 +48,7 @@ func (b *Backend) DeleteWorkspace(name string) error {
 	}
 
 	// Setup the state's blob.
-	blob, err := blob.Setup(b.container, name) // blob name = workspace name.
+	blob, err := blob.Setup(b.container, name, b.retryPolicy) // blob name = workspace name.
 	if err != nil {
 		return fmt.Errorf("error setting up state blob: %s", err)
 	}
@@ -56,7 +63,7 @@ func (b *Backend) DeleteWorkspace(name string) error {
 // StateMgr returns the state of the given workspace name.
 func (b *Backend) StateMgr(workspaceName string) (state.State, error) {
 	// Setup blob.
-	blob, err := blob.Setup(b.container, workspaceName)
+	blob, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("error setting up state blob: %s", err)
 	}
@@ -67,14 +74,154 @@ func (b *Backend) StateMgr(workspaceName string) (state.State, error) {
 		return nil, fmt.Errorf("error setting up state key vault: %s", err)
 	}
 
-	return &remote.State{Blob: blob, KeyVault: keyVault, Props: &b.props}, nil
+	// Setup the secret store backing masked state attributes.
+	secretStore, err := b.setupSecretStore(workspaceName, keyVault)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up secret store: %s", err)
+	}
+
+	// Tag every snapshot Put/PersistState takes with who (the backend's own
+	// object ID) and which Terraform run (TF_RUN_ID, set by CI convention)
+	// caused it, so ListStateSnapshots can report provenance.
+	blob.SnapshotTags = map[string]string{
+		"principal": b.props.ObjectID,
+		"run_id":    os.Getenv("TF_RUN_ID"),
+	}
+
+	// Wire up a dedicated audit container when configured, so every
+	// Put/Delete/Lock/Unlock below gets recorded to its own append blob in a
+	// container that can be locked down with an immutability policy
+	// independently of the (necessarily mutable) state container.
+	if b.props.AuditContainerName != "" {
+		auditContainer, err := account.SetupAuditContainer(context.Background(), &b.props, b.props.AuditContainerName)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up audit container: %s", err)
+		}
+		blob.SetAuditContainer(auditContainer)
+	}
+
+	return &remote.State{Blob: blob, KeyVault: keyVault, SecretStore: secretStore, Props: &b.props, SnapshotRetention: b.snapshotRetention, Output: b.ColorOutput}, nil
+}
+
+// ListStateSnapshots returns the snapshots available for workspaceName's
+// state blob, backing the "terraform state snapshots" subcommand so an
+// operator can see what points in time are available to restore to.
+func (b *Backend) ListStateSnapshots(workspaceName string) ([]blob.SnapshotInfo, error) {
+	blb, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up state blob: %s", err)
+	}
+	return blb.ListSnapshots()
+}
+
+// RestoreStateSnapshot restores workspaceName's state blob to the snapshot
+// taken at snapshotTime, backing the "terraform state restore" subcommand
+// so an operator can recover from a bad apply without hand-editing blobs in
+// the portal. The blob is locked for the duration of the restore so a
+// concurrent operation can't race it.
+func (b *Backend) RestoreStateSnapshot(workspaceName string, snapshotTime time.Time) error {
+	blb, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
+	if err != nil {
+		return fmt.Errorf("error setting up state blob: %s", err)
+	}
+
+	lockInfo := state.NewLockInfo()
+	lockInfo.Operation = "RestoreStateSnapshot"
+	leaseID, err := blb.Lock(lockInfo)
+	if err != nil {
+		return fmt.Errorf("error locking blob: %s", err)
+	}
+	defer blb.Unlock(leaseID) // best effort; the lease expires on its own otherwise.
+
+	return blb.RestoreSnapshot(snapshotTime)
+}
+
+// GetStateSnapshot returns the state payload as of the snapshot taken at
+// snapshotTime, so a caller can inspect (or diff) a past state without
+// restoring over the current one first.
+func (b *Backend) GetStateSnapshot(workspaceName string, snapshotTime time.Time) (*tfremote.Payload, error) {
+	blb, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up state blob: %s", err)
+	}
+	data, _, err := blb.GetSnapshot(snapshotTime)
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshot: %s", err)
+	}
+	return &tfremote.Payload{Data: data}, nil
+}
+
+// ReadAuditLog returns workspaceName's state blob's audit log entries since
+// since, so operators can prove who changed state and when — critical for
+// regulated environments where the state file holds sensitive infra.
+func (b *Backend) ReadAuditLog(workspaceName string, since time.Time) ([]blob.AuditEntry, error) {
+	blb, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up state blob: %s", err)
+	}
+	if b.props.AuditContainerName != "" {
+		auditContainer, err := account.SetupAuditContainer(context.Background(), &b.props, b.props.AuditContainerName)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up audit container: %s", err)
+		}
+		blb.SetAuditContainer(auditContainer)
+	}
+	return blb.ReadAuditLog(since)
+}
+
+// PruneStateSnapshots deletes workspaceName's state blob snapshots that
+// policy's retention rules don't keep, backing a "terraform state snapshots
+// prune" subcommand for manual cleanup outside of the automatic pruning
+// PersistState already runs on every apply when SnapshotRetention is
+// configured (see remote.State.SnapshotRetention).
+func (b *Backend) PruneStateSnapshots(workspaceName string, policy blob.RetentionPolicy) error {
+	blb, err := blob.Setup(b.container, workspaceName, b.retryPolicy)
+	if err != nil {
+		return fmt.Errorf("error setting up state blob: %s", err)
+	}
+	return blb.PruneSnapshots(policy)
 }
 
 // setupKeyVault setups the state/workspace's key vault.
 func (b *Backend) setupKeyVault(workspaceName string) (*keyvault.KeyVault, error) {
-	keyVault, err := keyvault.Setup(context.Background(), &b.props, workspaceName)
+	keyVault, err := keyvault.Setup(context.Background(), &b.props, b.creds, workspaceName, b.keyVaultAuthMode, b.retryPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("error setting up key vault: %s", err)
 	}
 	return keyVault, nil
 }
+
+// setupSecretStore builds the secretstore.Store that backs workspaceName's
+// masked state attributes, according to b.secretStoreType. keyVault is the
+// workspace's already-provisioned Key Vault (still needed regardless of
+// secretStoreType for access policy reconciliation and envelope encryption;
+// see remote.State.KeyVault), reused as the store when secretStoreType
+// selects Key Vault.
+func (b *Backend) setupSecretStore(workspaceName string, keyVault *keyvault.KeyVault) (secretstore.Store, error) {
+	switch b.secretStoreType {
+	case "", "azure_key_vault":
+		return secretstore.NewKeyVaultStore(keyVault), nil
+	case "vault":
+		client, err := vaultapi.NewClient(&vaultapi.Config{Address: b.vaultConfig.Address})
+		if err != nil {
+			return nil, fmt.Errorf("error creating vault client: %s", err)
+		}
+		if err := secretstore.Authenticate(client, secretstore.VaultAuthConfig{
+			AuthMethod: b.vaultConfig.AuthMethod,
+			Token:      b.vaultConfig.Token,
+			RoleID:     b.vaultConfig.RoleID,
+			SecretID:   b.vaultConfig.SecretID,
+		}); err != nil {
+			return nil, fmt.Errorf("error authenticating to vault: %s", err)
+		}
+		if b.vaultConfig.Namespace != "" {
+			client.SetNamespace(b.vaultConfig.Namespace)
+		}
+		// Scope the mount path per workspace so secrets for different
+		// workspaces can't collide, the same isolation separate per-workspace
+		// key vaults give the default backend.
+		return secretstore.NewVault(client, b.vaultConfig.MountPath+"/"+workspaceName), nil
+	default:
+		return nil, fmt.Errorf("unknown secret_store %q", b.secretStoreType)
+	}
+}